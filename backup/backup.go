@@ -0,0 +1,273 @@
+//Package backup keeps versioned partition backups, each with a JSON manifest describing where it came from
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//timeFormat names backups so they sort by age
+const timeFormat = "20060102-150405"
+
+//Manifest describes a single backup image
+type Manifest struct {
+	Partition string    `json:"partition"` //partition name without its slot suffix, such as boot
+	Slot      string    `json:"slot"`      //slot suffix, such as _a, or empty for partitions without slots
+	Device    string    `json:"device"`    //block device the backup was read from
+	Time      time.Time `json:"time"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Kernel    string    `json:"kernel"` //kernel version running when the backup was made
+	Tool      string    `json:"tool"`   //tool that made the backup
+	Image     string    `json:"image"`  //file name of the backup image, next to the manifest
+
+	path string
+}
+
+//Name returns the partition name with its slot suffix, such as boot_a
+func (m *Manifest) Name() string {
+	return m.Partition + m.Slot
+}
+
+//Path returns the path to the manifest
+func (m *Manifest) Path() string {
+	return m.path
+}
+
+//ImagePath returns the path to the backup image
+func (m *Manifest) ImagePath() string {
+	return filepath.Join(filepath.Dir(m.path), m.Image)
+}
+
+//Verify checks the backup image against the size and hash in the manifest
+func (m *Manifest) Verify() error {
+	size, hash, err := hashFile(m.ImagePath())
+	if err != nil {
+		return err
+	}
+	if size != m.Size || hash != m.SHA256 {
+		return fmt.Errorf("backup image [%s] is %d bytes with sha256 %s, but its manifest expects %d bytes with sha256 %s", m.ImagePath(), size, hash, m.Size, m.SHA256)
+	}
+	return nil
+}
+
+//Load reads a manifest
+func Load(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing backup manifest [%s]: %v", path, err)
+	}
+	m.path = path
+	return m, nil
+}
+
+//Store holds backups in a directory, keyed by partition, slot and time
+type Store struct {
+	Dir  string
+	Keep int //backups to keep per partition and slot, or <= 0 to keep all of them
+}
+
+//NewStore returns a backup store in dir, keeping the newest keep backups of each partition and slot
+func NewStore(dir string, keep int) *Store {
+	return &Store{Dir: dir, Keep: keep}
+}
+
+//SplitSlot splits a partition name such as boot_a into its name and slot suffix
+func SplitSlot(name string) (string, string) {
+	if strings.HasSuffix(name, "_a") || strings.HasSuffix(name, "_b") {
+		return name[:len(name)-2], name[len(name)-2:]
+	}
+	return name, ""
+}
+
+//dir returns the directory holding the backups of a partition and slot
+func (s *Store) dir(partition, slot string) string {
+	slotDir := strings.TrimPrefix(slot, "_")
+	if slotDir == "" {
+		slotDir = "none"
+	}
+	return filepath.Join(s.Dir, partition, slotDir)
+}
+
+//Add backs up a block device as the partition name (with its slot suffix, if any), then removes backups beyond the retention limit
+//
+//Errors removing old backups are printed instead of returned, as the new backup was still made
+func (s *Store) Add(device, name, tool string) (*Manifest, error) {
+	partition, slot := SplitSlot(name)
+	now := time.Now()
+	dir := s.dir(partition, slot)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	base := uniqueName(dir, now.Format(timeFormat))
+	m := &Manifest{
+		Partition: partition,
+		Slot:      slot,
+		Device:    device,
+		Time:      now,
+		Kernel:    kernelVersion(),
+		Tool:      tool,
+		Image:     base + ".img",
+		path:      filepath.Join(dir, base+".json"),
+	}
+
+	var err error
+	m.Size, m.SHA256, err = copyFile(device, m.ImagePath())
+	if err != nil {
+		os.Remove(m.ImagePath())
+		return nil, fmt.Errorf("error backing up [%s]: %v", device, err)
+	}
+	if err := m.Verify(); err != nil {
+		os.Remove(m.ImagePath())
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(m.path, data, 0644); err != nil {
+		return nil, err
+	}
+
+	//The backup is done, so failing to remove old ones shouldn't fail whatever it was made for
+	if err := s.Prune(partition, slot); err != nil {
+		fmt.Fprintf(os.Stderr, "error removing old backups of [%s]: %v\n", name, err)
+	}
+	return m, nil
+}
+
+//uniqueName returns base, or base followed by a counter if a backup named base already exists in dir, such as when two are made within a second
+func uniqueName(dir, base string) string {
+	name := base
+	for i := 2; ; i++ {
+		_, errImage := os.Lstat(filepath.Join(dir, name+".img"))
+		_, errManifest := os.Lstat(filepath.Join(dir, name+".json"))
+		if os.IsNotExist(errImage) && os.IsNotExist(errManifest) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+//List returns every backup in the store, newest first
+func (s *Store) List() ([]*Manifest, error) {
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "*", "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make([]*Manifest, 0)
+	for _, path := range paths {
+		m, err := Load(path)
+		if err != nil {
+			continue //Skip anything that isn't ours
+		}
+		manifests = append(manifests, m)
+	}
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return manifests[i].Time.After(manifests[j].Time)
+	})
+	return manifests, nil
+}
+
+//Prune removes the oldest backups of a partition and slot beyond the retention limit
+func (s *Store) Prune(partition, slot string) error {
+	if s.Keep <= 0 {
+		return nil
+	}
+
+	manifests, err := s.List()
+	if err != nil {
+		return err
+	}
+	kept := 0
+	for _, m := range manifests {
+		if m.Partition != partition || m.Slot != slot {
+			continue
+		}
+		kept++
+		if kept <= s.Keep {
+			continue
+		}
+		if err := os.Remove(m.ImagePath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(m.Path()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//copyFile copies src to a new file at dst, returning the size and SHA-256 of what was copied
+func copyFile(src, dst string) (int64, string, error) {
+	source, err := os.Open(src)
+	if err != nil {
+		return 0, "", err
+	}
+	defer source.Close()
+
+	destination, err := os.Create(dst)
+	if err != nil {
+		return 0, "", err
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(destination, h), source)
+	if err != nil {
+		destination.Close()
+		return 0, "", err
+	}
+	if err := destination.Sync(); err != nil {
+		destination.Close()
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), destination.Close()
+}
+
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//kernelVersion returns the release of the running kernel, such as 4.19.157-perf+
+func kernelVersion() string {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return ""
+	}
+	release := make([]byte, 0, len(uts.Release))
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+	return string(release)
+}
@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//touch creates empty files in dir
+func touch(t *testing.T, dir string, names ...string) {
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Existing []string
+		Want     string
+	}{
+		{"empty", nil, "20240101-100000"},
+		{"other backups", []string{"20240101-095959.img", "20240101-095959.json"}, "20240101-100000"},
+		{"image", []string{"20240101-100000.img"}, "20240101-100000-2"},
+		{"manifest", []string{"20240101-100000.json"}, "20240101-100000-2"},
+		{"counted", []string{"20240101-100000.img", "20240101-100000.json", "20240101-100000-2.json"}, "20240101-100000-3"},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			touch(t, dir, test.Existing...)
+			if got := uniqueName(dir, "20240101-100000"); got != test.Want {
+				t.Errorf("got %s, want %s", got, test.Want)
+			}
+		})
+	}
+}
+
+//TestAdd backs up a file-backed partition twice within a second and loads both manifests back
+func TestAdd(t *testing.T) {
+	dir := t.TempDir()
+	device := filepath.Join(dir, "boot_a")
+	if err := ioutil.WriteFile(device, []byte("boot image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(filepath.Join(dir, "backups"), 0)
+
+	first, err := store.Add(device, "boot_a", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Add(device, "boot_a", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ImagePath() == second.ImagePath() {
+		t.Fatalf("both backups were written to [%s]", first.ImagePath())
+	}
+
+	for _, m := range []*Manifest{first, second} {
+		loaded, err := Load(m.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !loaded.Time.Equal(m.Time) {
+			t.Errorf("loaded time %v, want %v", loaded.Time, m.Time)
+		}
+		loaded.Time = m.Time
+		if !reflect.DeepEqual(loaded, m) {
+			t.Errorf("loaded %+v, want %+v", loaded, m)
+		}
+		if m.Name() != "boot_a" || m.Partition != "boot" || m.Slot != "_a" || m.Size != 10 || m.Device != device {
+			t.Errorf("got manifest %+v", m)
+		}
+		if err := m.Verify(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err := ioutil.WriteFile(first.ImagePath(), []byte("corrupted!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Verify(); err == nil {
+		t.Error("a corrupted backup image passed verification")
+	}
+}
+
+//manifest writes a backup of name made at minute of 2024-01-01 10:00 to the store, returning its image file name
+func manifest(t *testing.T, store *Store, name string, minute int) string {
+	partition, slot := SplitSlot(name)
+	dir := store.dir(partition, slot)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 1, 10, minute, 0, 0, time.UTC)
+	m := &Manifest{Partition: partition, Slot: slot, Time: now, Image: now.Format(timeFormat) + ".img"}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	touch(t, dir, m.Image)
+	if err := ioutil.WriteFile(filepath.Join(dir, now.Format(timeFormat)+".json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name + "/" + m.Image
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		Name string
+		Keep int
+		Want []string
+	}{
+		{"keep all", 0, []string{"boot_a/20240101-100400.img", "boot_a/20240101-100300.img", "boot_a/20240101-100200.img", "boot_a/20240101-100100.img"}},
+		{"keep newest", 2, []string{"boot_a/20240101-100400.img", "boot_a/20240101-100300.img"}},
+		{"keep one", 1, []string{"boot_a/20240101-100400.img"}},
+		{"keep more than there are", 10, []string{"boot_a/20240101-100400.img", "boot_a/20240101-100300.img", "boot_a/20240101-100200.img", "boot_a/20240101-100100.img"}},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			store := NewStore(t.TempDir(), test.Keep)
+			//Written out of order, so only their times can sort them
+			for _, minute := range []int{2, 4, 1, 3} {
+				manifest(t, store, "boot_a", minute)
+			}
+			others := []string{manifest(t, store, "boot_b", 0), manifest(t, store, "dtbo", 0)}
+
+			if err := store.Prune("boot", "_a"); err != nil {
+				t.Fatal(err)
+			}
+			manifests, err := store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0)
+			for _, m := range manifests {
+				if _, err := os.Stat(m.ImagePath()); err != nil {
+					t.Errorf("manifest of %s kept without its image: %v", m.Name(), err)
+				}
+				got = append(got, m.Name()+"/"+m.Image)
+			}
+			want := append(test.Want, others...)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
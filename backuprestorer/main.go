@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	flag "github.com/spf13/pflag"
+	"github.com/JoshuaDoes/jdtoolbox/backup"
+	"github.com/JoshuaDoes/jdtoolbox/flash"
+	"github.com/JoshuaDoes/jdtoolbox/partition"
+)
+
+var (
+	manifest, device string
+	root string
+	backups string
+	dryRun bool
+
+	plan *flash.Plan
+)
+
+func init() {
+	flag.StringVar(&manifest, "manifest", "", "path to manifest of backup to restore")
+	flag.StringVar(&device, "device", "", "path to partition to restore to, defaults to the partition the backup was made from")
+	flag.StringVar(&root, "root", "/", "path to the root holding dev and sys, used to find the partition the backup was made from")
+	flag.StringVar(&backups, "backups", "/sdcard/jdtoolbox/backups", "path to backup store for the partition before it's restored")
+	flag.BoolVar(&dryRun, "dry-run", false, "verify the backup and print a plan without writing any partitions")
+	flag.Parse()
+
+	if manifest == "" && flag.NArg() > 0 {
+		manifest = flag.Arg(0)
+	}
+	if _, err := os.Stat(manifest); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	//Never prune here, the backup being restored could be the oldest one
+	plan = flash.NewPlan(dryRun, backup.NewStore(backups, 0), "bkrestore")
+}
+
+func log(msg string) {
+	if msg == "" {
+		fmt.Print("\n")
+	} else {
+		fmt.Println("  • " + msg)
+	}
+}
+func check(err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	m, err := backup.Load(manifest)
+	check(err)
+	log(fmt.Sprintf("Backup of %s made by %s at %s", m.Name(), m.Tool, m.Time.Format("2006-01-02 15:04:05")))
+	if m.Kernel != "" {
+		log("Kernel running at the time: " + m.Kernel)
+	}
+
+	log("Verifying backup image [" + m.ImagePath() + "]...")
+	check(m.Verify())
+	log("Successfully verified backup as sha256 " + m.SHA256)
+
+	if device == "" {
+		//Look the partition up by name first, as block device numbers can change between boots
+		device = m.Device
+		if parts, err := partition.Open(root); err == nil {
+			if found, err := parts.Find(m.Name()); err == nil {
+				device = found
+			}
+		}
+	}
+	if _, err := os.Stat(device); err != nil {
+		check(fmt.Errorf("partition [%s] for %s is not available: %v", device, m.Name(), err))
+	}
+
+	if dryRun {
+		log("Dry run, no partitions will be written")
+	} else {
+		log("Backing up current " + m.Name() + " to [" + backups + "]...")
+		current, err := plan.Backup(device, m.Name())
+		check(err)
+		log("Backed up " + current.Name() + " to [" + current.ImagePath() + "]")
+
+		log("Restoring " + m.Name() + "...")
+	}
+	plan.Source("backup", m.ImagePath(), m.Tool+" backup of "+m.Name())
+	check(plan.Flash(m.ImagePath(), device))
+
+	log("")
+	plan.Print(log)
+}
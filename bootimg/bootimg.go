@@ -0,0 +1,417 @@
+//Package bootimg reads and writes Android boot images (header versions 0 through 4) and vendor boot images (header versions 3 and 4)
+package bootimg
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	BootMagic       = "ANDROID!"
+	VendorBootMagic = "VNDRBOOT"
+
+	bootV3PageSize = 4096
+
+	bootV0HeaderSize = 1632
+	bootV1HeaderSize = 1648
+	bootV2HeaderSize = 1660
+	bootV3HeaderSize = 1580
+	bootV4HeaderSize = 1584
+
+	bootNameSize      = 16
+	bootArgsSize      = 512
+	bootExtraArgsSize = 1024
+	bootV3ArgsSize    = 1536
+	bootIDSize        = 32
+)
+
+var (
+	ErrMagic       = errors.New("bootimg: invalid magic")
+	ErrVersion     = errors.New("bootimg: unsupported header version")
+	ErrTruncated   = errors.New("bootimg: image is truncated")
+	ErrTooLarge    = errors.New("bootimg: image does not fit in its original size")
+	ErrFieldLength = errors.New("bootimg: string is too long for its header field")
+)
+
+//Image holds an Android boot image
+type Image struct {
+	HeaderVersion uint32
+	PageSize      uint32 //always 4096 for header versions 3 and 4
+
+	KernelAddr  uint32 //header versions 0 through 2
+	RamdiskAddr uint32 //header versions 0 through 2
+	SecondAddr  uint32 //header versions 0 through 2
+	TagsAddr    uint32 //header versions 0 through 2
+	DTBAddr     uint64 //header version 2
+
+	OSVersion    OSVersion
+	Name         string //header versions 0 through 2
+	Cmdline      string
+	ExtraCmdline string   //header versions 0 through 2
+	ID           [32]byte //header versions 0 through 2, recalculated when written
+
+	Kernel       []byte
+	Ramdisk      []byte
+	Second       []byte //header versions 0 through 2
+	RecoveryDTBO []byte //header versions 1 and 2
+	DTB          []byte //header version 2
+	Signature    []byte //header version 4
+
+	Trailer
+	header []byte //raw header, used to preserve unknown and reserved fields
+}
+
+//Open reads and parses a boot image from a file or block device
+func Open(path string) (*Image, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+//Parse parses a boot image, including any trailing data or AVB footer
+func Parse(data []byte) (*Image, error) {
+	if len(data) < 44 {
+		return nil, ErrTruncated
+	}
+	if string(data[:8]) != BootMagic {
+		return nil, ErrMagic
+	}
+
+	img := &Image{HeaderVersion: le.Uint32(data[40:])}
+	payload, err := img.Trailer.parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &reader{data: payload}
+	switch img.HeaderVersion {
+	case 0, 1, 2:
+		headerSize := bootV0HeaderSize
+		if img.HeaderVersion == 1 {
+			headerSize = bootV1HeaderSize
+		} else if img.HeaderVersion == 2 {
+			headerSize = bootV2HeaderSize
+		}
+		if len(payload) < headerSize {
+			return nil, ErrTruncated
+		}
+		img.header = append([]byte(nil), payload[:headerSize]...)
+
+		h := img.header
+		img.KernelAddr = le.Uint32(h[12:])
+		img.RamdiskAddr = le.Uint32(h[20:])
+		img.SecondAddr = le.Uint32(h[28:])
+		img.TagsAddr = le.Uint32(h[32:])
+		img.PageSize = le.Uint32(h[36:])
+		img.OSVersion = OSVersion(le.Uint32(h[44:]))
+		img.Name = cstring(h[48 : 48+bootNameSize])
+		img.Cmdline = cstring(h[64 : 64+bootArgsSize])
+		copy(img.ID[:], h[576:576+bootIDSize])
+		img.ExtraCmdline = cstring(h[608 : 608+bootExtraArgsSize])
+		if img.PageSize == 0 {
+			return nil, fmt.Errorf("bootimg: invalid page size %d", img.PageSize)
+		}
+
+		r.page = int(img.PageSize)
+		r.skip(headerSize)
+		if img.Kernel, err = r.section(le.Uint32(h[8:])); err != nil {
+			return nil, err
+		}
+		if img.Ramdisk, err = r.section(le.Uint32(h[16:])); err != nil {
+			return nil, err
+		}
+		if img.Second, err = r.section(le.Uint32(h[24:])); err != nil {
+			return nil, err
+		}
+		if img.HeaderVersion >= 1 {
+			if img.RecoveryDTBO, err = r.section(le.Uint32(h[1632:])); err != nil {
+				return nil, err
+			}
+		}
+		if img.HeaderVersion >= 2 {
+			if img.DTB, err = r.section(le.Uint32(h[1648:])); err != nil {
+				return nil, err
+			}
+			img.DTBAddr = le.Uint64(h[1652:])
+		}
+	case 3, 4:
+		headerSize := bootV3HeaderSize
+		if img.HeaderVersion == 4 {
+			headerSize = bootV4HeaderSize
+		}
+		if len(payload) < headerSize {
+			return nil, ErrTruncated
+		}
+		img.header = append([]byte(nil), payload[:headerSize]...)
+
+		h := img.header
+		img.PageSize = bootV3PageSize
+		img.OSVersion = OSVersion(le.Uint32(h[16:]))
+		img.Cmdline = cstring(h[44 : 44+bootV3ArgsSize])
+
+		r.page = bootV3PageSize
+		r.skip(headerSize)
+		if img.Kernel, err = r.section(le.Uint32(h[8:])); err != nil {
+			return nil, err
+		}
+		if img.Ramdisk, err = r.section(le.Uint32(h[12:])); err != nil {
+			return nil, err
+		}
+		if img.HeaderVersion == 4 {
+			if img.Signature, err = r.section(le.Uint32(h[1580:])); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, ErrVersion
+	}
+	img.Trailer.parseTail(payload, r.offset)
+	return img, nil
+}
+
+//Bytes returns the boot image as it would be written, recalculating sizes, offsets and the ID
+func (img *Image) Bytes() ([]byte, error) {
+	w := &writer{}
+	switch img.HeaderVersion {
+	case 0, 1, 2:
+		headerSize := bootV0HeaderSize
+		if img.HeaderVersion == 1 {
+			headerSize = bootV1HeaderSize
+		} else if img.HeaderVersion == 2 {
+			headerSize = bootV2HeaderSize
+		}
+		if img.PageSize == 0 {
+			return nil, fmt.Errorf("bootimg: invalid page size %d", img.PageSize)
+		}
+
+		h := make([]byte, headerSize)
+		copy(h, img.header)
+		copy(h, BootMagic)
+		le.PutUint32(h[8:], uint32(len(img.Kernel)))
+		le.PutUint32(h[12:], img.KernelAddr)
+		le.PutUint32(h[16:], uint32(len(img.Ramdisk)))
+		le.PutUint32(h[20:], img.RamdiskAddr)
+		le.PutUint32(h[24:], uint32(len(img.Second)))
+		le.PutUint32(h[28:], img.SecondAddr)
+		le.PutUint32(h[32:], img.TagsAddr)
+		le.PutUint32(h[36:], img.PageSize)
+		le.PutUint32(h[40:], img.HeaderVersion)
+		le.PutUint32(h[44:], uint32(img.OSVersion))
+		if err := putCString(h[48:48+bootNameSize], img.Name); err != nil {
+			return nil, err
+		}
+		if err := putCString(h[64:64+bootArgsSize], img.Cmdline); err != nil {
+			return nil, err
+		}
+		if err := putCString(h[608:608+bootExtraArgsSize], img.ExtraCmdline); err != nil {
+			return nil, err
+		}
+
+		w.page = int(img.PageSize)
+		w.section(h)
+		w.section(img.Kernel)
+		w.section(img.Ramdisk)
+		w.section(img.Second)
+		if img.HeaderVersion >= 1 {
+			le.PutUint32(h[1632:], uint32(len(img.RecoveryDTBO)))
+			offset := uint64(0)
+			if len(img.RecoveryDTBO) > 0 {
+				offset = uint64(len(w.buf))
+			}
+			le.PutUint64(h[1636:], offset)
+			if le.Uint32(h[1644:]) == 0 {
+				le.PutUint32(h[1644:], uint32(headerSize))
+			}
+			w.section(img.RecoveryDTBO)
+		}
+		if img.HeaderVersion >= 2 {
+			le.PutUint32(h[1648:], uint32(len(img.DTB)))
+			le.PutUint64(h[1652:], img.DTBAddr)
+			w.section(img.DTB)
+		}
+
+		img.ID = img.id()
+		copy(h[576:576+bootIDSize], img.ID[:])
+		copy(w.buf, h) //The header was written before its offsets and ID were known
+	case 3, 4:
+		headerSize := bootV3HeaderSize
+		if img.HeaderVersion == 4 {
+			headerSize = bootV4HeaderSize
+		}
+
+		h := make([]byte, headerSize)
+		copy(h, img.header)
+		copy(h, BootMagic)
+		le.PutUint32(h[8:], uint32(len(img.Kernel)))
+		le.PutUint32(h[12:], uint32(len(img.Ramdisk)))
+		le.PutUint32(h[16:], uint32(img.OSVersion))
+		le.PutUint32(h[20:], uint32(headerSize))
+		le.PutUint32(h[40:], img.HeaderVersion)
+		if err := putCString(h[44:44+bootV3ArgsSize], img.Cmdline); err != nil {
+			return nil, err
+		}
+		if img.HeaderVersion == 4 {
+			le.PutUint32(h[1580:], uint32(len(img.Signature)))
+		}
+
+		w.page = bootV3PageSize
+		w.section(h)
+		w.section(img.Kernel)
+		w.section(img.Ramdisk)
+		if img.HeaderVersion == 4 {
+			w.section(img.Signature)
+		}
+	default:
+		return nil, ErrVersion
+	}
+
+	return img.Trailer.append(w.buf)
+}
+
+//WriteFile writes the boot image to a file
+func (img *Image) WriteFile(path string) error {
+	data, err := img.Bytes()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+//id calculates the boot image ID the same way mkbootimg does, using SHA-256 if the original ID was too long for SHA-1
+func (img *Image) id() (id [32]byte) {
+	var h hash.Hash = sha1.New()
+	if !allZero(img.ID[sha1.Size:]) {
+		h = sha256.New()
+	}
+
+	sections := [][]byte{img.Kernel, img.Ramdisk, img.Second}
+	if img.HeaderVersion >= 1 {
+		sections = append(sections, img.RecoveryDTBO)
+	}
+	if img.HeaderVersion >= 2 {
+		sections = append(sections, img.DTB)
+	}
+	size := make([]byte, 4)
+	for _, section := range sections {
+		h.Write(section)
+		le.PutUint32(size, uint32(len(section)))
+		h.Write(size)
+	}
+
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+//IsBoot returns true if the data starts with a boot image header
+func IsBoot(data []byte) bool {
+	return len(data) >= 8 && string(data[:8]) == BootMagic
+}
+
+//IsVendorBoot returns true if the data starts with a vendor boot image header
+func IsVendorBoot(data []byte) bool {
+	return len(data) >= 8 && string(data[:8]) == VendorBootMagic
+}
+
+//HeaderVersion returns the header version of a boot or vendor boot image file without parsing the rest of it
+func HeaderVersion(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	h := make([]byte, 44)
+	if _, err := f.ReadAt(h, 0); err != nil {
+		return 0, err
+	}
+	switch {
+	case IsBoot(h):
+		return le.Uint32(h[40:]), nil
+	case IsVendorBoot(h):
+		return le.Uint32(h[8:]), nil
+	}
+	return 0, ErrMagic
+}
+
+var le = binary.LittleEndian
+
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func putCString(b []byte, s string) error {
+	if len(s) >= len(b) {
+		return ErrFieldLength
+	}
+	for i := range b {
+		b[i] = 0
+	}
+	copy(b, s)
+	return nil
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func align(n, page int) int {
+	if page <= 0 {
+		return n
+	}
+	return (n + page - 1) / page * page
+}
+
+//reader walks the page-aligned sections of an image
+type reader struct {
+	data   []byte
+	page   int
+	offset int
+}
+
+func (r *reader) skip(n int) {
+	r.offset = align(r.offset+n, r.page)
+}
+
+func (r *reader) section(size uint32) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	end := r.offset + int(size)
+	if end > len(r.data) || end < r.offset {
+		return nil, ErrTruncated
+	}
+	section := append([]byte(nil), r.data[r.offset:end]...)
+	r.offset = align(end, r.page)
+	return section, nil
+}
+
+//writer builds the page-aligned sections of an image
+type writer struct {
+	buf  []byte
+	page int
+}
+
+func (w *writer) section(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	w.buf = append(w.buf, data...)
+	w.buf = append(w.buf, make([]byte, align(len(w.buf), w.page)-len(w.buf))...)
+}
@@ -0,0 +1,338 @@
+package bootimg
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+var (
+	testKernel    = bytes.Repeat([]byte("kernel"), 700)
+	testRamdisk   = bytes.Repeat([]byte("ramdisk"), 300)
+	testSecond    = []byte("second stage")
+	testDTBO      = []byte("recovery dtbo")
+	testDTB       = []byte("device tree blob")
+	testSignature = []byte("boot signature")
+	testVBMeta    = bytes.Repeat([]byte("vbmeta"), 100)
+	testOSVersion = NewOSVersion(11, 0, 0, 2021, 5)
+)
+
+//pad appends zeroes to data up to the next multiple of page
+func pad(data []byte, page int) []byte {
+	return append(data, make([]byte, align(len(data), page)-len(data))...)
+}
+
+//bootFixture builds a boot image by hand, the way mkbootimg lays it out, independently of Bytes
+func bootFixture(version uint32) []byte {
+	page := 2048
+	headerSize := map[uint32]int{0: bootV0HeaderSize, 1: bootV1HeaderSize, 2: bootV2HeaderSize, 3: bootV3HeaderSize, 4: bootV4HeaderSize}[version]
+	h := make([]byte, headerSize)
+	copy(h, BootMagic)
+	le.PutUint32(h[40:], version)
+
+	if version >= 3 {
+		page = bootV3PageSize
+		le.PutUint32(h[8:], uint32(len(testKernel)))
+		le.PutUint32(h[12:], uint32(len(testRamdisk)))
+		le.PutUint32(h[16:], uint32(testOSVersion))
+		le.PutUint32(h[20:], uint32(headerSize))
+		copy(h[44:], "console=ttyMSM0 androidboot.hardware=qcom")
+		if version == 4 {
+			le.PutUint32(h[1580:], uint32(len(testSignature)))
+		}
+		out := pad(h, page)
+		out = append(out, pad(append([]byte(nil), testKernel...), page)...)
+		out = append(out, pad(append([]byte(nil), testRamdisk...), page)...)
+		if version == 4 {
+			out = append(out, pad(append([]byte(nil), testSignature...), page)...)
+		}
+		return out
+	}
+
+	le.PutUint32(h[8:], uint32(len(testKernel)))
+	le.PutUint32(h[12:], 0x00008000)
+	le.PutUint32(h[16:], uint32(len(testRamdisk)))
+	le.PutUint32(h[20:], 0x01000000)
+	le.PutUint32(h[24:], uint32(len(testSecond)))
+	le.PutUint32(h[28:], 0x00f00000)
+	le.PutUint32(h[32:], 0x00000100)
+	le.PutUint32(h[36:], uint32(page))
+	le.PutUint32(h[44:], uint32(testOSVersion))
+	copy(h[48:], "fixture")
+	copy(h[64:], "console=ttyMSM0")
+	copy(h[608:], "androidboot.selinux=permissive")
+
+	sections := [][]byte{testKernel, testRamdisk, testSecond}
+	if version >= 1 {
+		offset := len(pad(make([]byte, headerSize), page)) + len(pad(append([]byte(nil), testKernel...), page)) +
+			len(pad(append([]byte(nil), testRamdisk...), page)) + len(pad(append([]byte(nil), testSecond...), page))
+		le.PutUint32(h[1632:], uint32(len(testDTBO)))
+		le.PutUint64(h[1636:], uint64(offset))
+		le.PutUint32(h[1644:], uint32(headerSize))
+		sections = append(sections, testDTBO)
+	}
+	if version >= 2 {
+		le.PutUint32(h[1648:], uint32(len(testDTB)))
+		le.PutUint64(h[1652:], 0x01f00000)
+		sections = append(sections, testDTB)
+	}
+
+	id := sha1.New()
+	size := make([]byte, 4)
+	for _, section := range sections {
+		id.Write(section)
+		le.PutUint32(size, uint32(len(section)))
+		id.Write(size)
+	}
+	copy(h[576:], id.Sum(nil))
+
+	out := pad(h, page)
+	for _, section := range sections {
+		out = append(out, pad(append([]byte(nil), section...), page)...)
+	}
+	return out
+}
+
+//vendorFixture builds a vendor boot image by hand, the way mkbootimg lays it out, independently of Bytes
+func vendorFixture(version uint32) []byte {
+	page := 4096
+	headerSize := vendorBootV3HeaderSize
+	if version == 4 {
+		headerSize = vendorBootV4HeaderSize
+	}
+	ramdisks := [][]byte{testRamdisk}
+	if version == 4 {
+		ramdisks = append(ramdisks, []byte("dlkm ramdisk"))
+	}
+	ramdisk := bytes.Join(ramdisks, nil)
+
+	h := make([]byte, headerSize)
+	copy(h, VendorBootMagic)
+	le.PutUint32(h[8:], version)
+	le.PutUint32(h[12:], uint32(page))
+	le.PutUint32(h[16:], 0x00008000)
+	le.PutUint32(h[20:], 0x01000000)
+	le.PutUint32(h[24:], uint32(len(ramdisk)))
+	copy(h[28:], "androidboot.console=ttyMSM0")
+	le.PutUint32(h[2076:], 0x00000100)
+	copy(h[2080:], "vendor fixture")
+	le.PutUint32(h[2096:], uint32(headerSize))
+	le.PutUint32(h[2100:], uint32(len(testDTB)))
+	le.PutUint64(h[2104:], 0x01f00000)
+
+	table := make([]byte, 0)
+	bootconfig := []byte("androidboot.hardware=qcom\n")
+	if version == 4 {
+		offset := 0
+		for i, data := range ramdisks {
+			entry := make([]byte, vendorRamdiskEntrySize)
+			le.PutUint32(entry[0:], uint32(len(data)))
+			le.PutUint32(entry[4:], uint32(offset))
+			le.PutUint32(entry[8:], []uint32{VendorRamdiskTypePlatform, VendorRamdiskTypeDLKM}[i])
+			copy(entry[12:], fmt.Sprintf("ramdisk%d", i))
+			le.PutUint32(entry[44:], uint32(i+1))
+			table = append(table, entry...)
+			offset += len(data)
+		}
+		le.PutUint32(h[2112:], uint32(len(table)))
+		le.PutUint32(h[2116:], uint32(len(ramdisks)))
+		le.PutUint32(h[2120:], vendorRamdiskEntrySize)
+		le.PutUint32(h[2124:], uint32(len(bootconfig)))
+	}
+
+	out := pad(h, page)
+	out = append(out, pad(ramdisk, page)...)
+	out = append(out, pad(append([]byte(nil), testDTB...), page)...)
+	if version == 4 {
+		out = append(out, pad(table, page)...)
+		out = append(out, pad(bootconfig, page)...)
+	}
+	return out
+}
+
+//withAVB places an image in a partition of size bytes with a vbmeta blob and an AVB footer, the way avbtool add_hash_footer does
+func withAVB(image []byte, size int) []byte {
+	out := make([]byte, size)
+	copy(out, image)
+	offset := align(len(image), avbBlockSize)
+	copy(out[offset:], testVBMeta)
+
+	be := binary.BigEndian
+	f := out[size-AVBFooterSize:]
+	copy(f, AVBFooterMagic)
+	be.PutUint32(f[4:], 1)
+	be.PutUint32(f[8:], 0)
+	be.PutUint64(f[12:], uint64(len(image)))
+	be.PutUint64(f[20:], uint64(offset))
+	be.PutUint64(f[28:], uint64(len(testVBMeta)))
+	return out
+}
+
+const testPartitionSize = 64 * 1024
+
+func TestBootRoundTrip(t *testing.T) {
+	for version := uint32(0); version <= 4; version++ {
+		for _, avb := range []bool{false, true} {
+			t.Run(fmt.Sprintf("v%d/avb=%v", version, avb), func(t *testing.T) {
+				raw := bootFixture(version)
+				if avb {
+					raw = withAVB(raw, testPartitionSize)
+				}
+
+				img, err := Parse(raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if img.HeaderVersion != version {
+					t.Errorf("header version %d, expected %d", img.HeaderVersion, version)
+				}
+				if !bytes.Equal(img.Kernel, testKernel) || !bytes.Equal(img.Ramdisk, testRamdisk) {
+					t.Errorf("kernel or ramdisk differs")
+				}
+				if img.OSVersion != testOSVersion {
+					t.Errorf("OS version %s, expected %s", img.OSVersion, testOSVersion)
+				}
+				if version <= 2 && (img.Name != "fixture" || img.ExtraCmdline != "androidboot.selinux=permissive" || !bytes.Equal(img.Second, testSecond)) {
+					t.Errorf("name %q, extra cmdline %q or second stage differs", img.Name, img.ExtraCmdline)
+				}
+				if version >= 1 && version <= 2 && !bytes.Equal(img.RecoveryDTBO, testDTBO) {
+					t.Errorf("recovery dtbo differs")
+				}
+				if version == 2 && !bytes.Equal(img.DTB, testDTB) {
+					t.Errorf("dtb differs")
+				}
+				if version == 4 && !bytes.Equal(img.Signature, testSignature) {
+					t.Errorf("signature differs")
+				}
+				if (img.AVB != nil) != avb {
+					t.Errorf("AVB footer found: %v, expected %v", img.AVB != nil, avb)
+				}
+				if avb && !bytes.Equal(img.VBMeta, testVBMeta) {
+					t.Errorf("vbmeta differs")
+				}
+
+				out, err := img.Bytes()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(out, raw) {
+					t.Errorf("written image differs from the original (%d bytes, expected %d)", len(out), len(raw))
+				}
+			})
+		}
+	}
+}
+
+func TestBootModifiedKeepsAVBFooter(t *testing.T) {
+	raw := withAVB(bootFixture(2), testPartitionSize)
+	img, err := Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldID := img.ID
+	img.Kernel = bytes.Repeat([]byte("new kernel"), 1000)
+
+	out, err := img.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != testPartitionSize {
+		t.Fatalf("image is %d bytes, expected the partition size %d", len(out), testPartitionSize)
+	}
+	again, err := Parse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Kernel, img.Kernel) || !bytes.Equal(again.Ramdisk, testRamdisk) || !bytes.Equal(again.DTB, testDTB) {
+		t.Errorf("sections differ after writing a new kernel")
+	}
+	if again.ID == oldID {
+		t.Errorf("ID was not recalculated")
+	}
+	if again.AVB == nil || !bytes.Equal(again.VBMeta, testVBMeta) {
+		t.Fatalf("AVB footer or vbmeta was lost")
+	}
+	if again.AVB.OriginalImageSize <= uint64(len(bootFixture(2))) {
+		t.Errorf("original image size %d was not updated", again.AVB.OriginalImageSize)
+	}
+}
+
+func TestBootTooLarge(t *testing.T) {
+	img, err := Parse(withAVB(bootFixture(3), testPartitionSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.Kernel = make([]byte, testPartitionSize)
+	if _, err := img.Bytes(); err != ErrTooLarge {
+		t.Errorf("got error %v, expected %v", err, ErrTooLarge)
+	}
+}
+
+func TestBootTruncated(t *testing.T) {
+	raw := bootFixture(0)
+	if _, err := Parse(raw[:len(raw)-2048]); err != ErrTruncated {
+		t.Errorf("got error %v, expected %v", err, ErrTruncated)
+	}
+	if _, err := Parse(vendorFixture(3)); err != ErrMagic {
+		t.Errorf("got error %v, expected %v", err, ErrMagic)
+	}
+}
+
+func TestVendorRoundTrip(t *testing.T) {
+	for _, version := range []uint32{3, 4} {
+		for _, avb := range []bool{false, true} {
+			t.Run(fmt.Sprintf("v%d/avb=%v", version, avb), func(t *testing.T) {
+				raw := vendorFixture(version)
+				if avb {
+					raw = withAVB(raw, testPartitionSize)
+				}
+
+				img, err := ParseVendor(raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if img.Name != "vendor fixture" || img.Cmdline != "androidboot.console=ttyMSM0" {
+					t.Errorf("name %q or cmdline %q differs", img.Name, img.Cmdline)
+				}
+				if !bytes.Equal(img.DTB, testDTB) || !bytes.Equal(img.Ramdisks[0].Data, testRamdisk) {
+					t.Errorf("dtb or first ramdisk differs")
+				}
+				if version == 4 {
+					if len(img.Ramdisks) != 2 || img.Ramdisks[1].Name != "ramdisk1" || img.Ramdisks[1].Type != VendorRamdiskTypeDLKM || img.Ramdisks[1].BoardID[0] != 2 {
+						t.Errorf("vendor ramdisk table differs")
+					}
+					if string(img.Bootconfig) != "androidboot.hardware=qcom\n" {
+						t.Errorf("bootconfig %q differs", img.Bootconfig)
+					}
+				}
+				if (img.AVB != nil) != avb {
+					t.Errorf("AVB footer found: %v, expected %v", img.AVB != nil, avb)
+				}
+
+				out, err := img.Bytes()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(out, raw) {
+					t.Errorf("written image differs from the original (%d bytes, expected %d)", len(out), len(raw))
+				}
+			})
+		}
+	}
+}
+
+func TestHeaderVersionAndOSVersion(t *testing.T) {
+	a, b, c := testOSVersion.Version()
+	year, month := testOSVersion.PatchLevel()
+	if a != 11 || b != 0 || c != 0 || year != 2021 || month != 5 {
+		t.Errorf("OS version unpacked to %d.%d.%d (%d-%d)", a, b, c, year, month)
+	}
+	if testOSVersion.String() != "11.0.0 (2021-05)" {
+		t.Errorf("OS version string %q", testOSVersion.String())
+	}
+	if !IsBoot(bootFixture(1)) || !IsVendorBoot(vendorFixture(4)) || IsBoot(vendorFixture(4)) {
+		t.Errorf("magic detection failed")
+	}
+}
@@ -0,0 +1,142 @@
+package bootimg
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	AVBFooterMagic = "AVBf"
+	AVBFooterSize  = 64
+
+	avbBlockSize = 4096
+)
+
+//AVBFooter holds the Android Verified Boot footer found at the end of a partition image
+type AVBFooter struct {
+	VersionMajor      uint32
+	VersionMinor      uint32
+	OriginalImageSize uint64 //size of the image before vbmeta and the footer were appended, updated when written
+	VBMetaOffset      uint64 //offset of the vbmeta blob, updated when written
+	VBMetaSize        uint64
+}
+
+//Trailer holds everything found after the last section of an image, so it can be written back unchanged
+//
+//If an AVB footer is present, the image is padded to its original size so the footer stays at the end of the partition
+//The vbmeta blob is kept as is, so its hash descriptor will no longer match a modified image
+type Trailer struct {
+	AVB    *AVBFooter
+	VBMeta []byte //vbmeta blob referenced by the AVB footer
+	Tail   []byte //data directly after the last section, such as SEANDROIDENFORCE, without trailing padding
+	Size   int64  //size of the original image, including padding
+}
+
+//parse reads the AVB footer if one exists and returns the data that makes up the image itself
+func (t *Trailer) parse(data []byte) ([]byte, error) {
+	t.Size = int64(len(data))
+	if len(data) < AVBFooterSize {
+		return data, nil
+	}
+
+	footer := data[len(data)-AVBFooterSize:]
+	if string(footer[:4]) != AVBFooterMagic {
+		return data, nil
+	}
+
+	be := binary.BigEndian
+	t.AVB = &AVBFooter{
+		VersionMajor:      be.Uint32(footer[4:]),
+		VersionMinor:      be.Uint32(footer[8:]),
+		OriginalImageSize: be.Uint64(footer[12:]),
+		VBMetaOffset:      be.Uint64(footer[20:]),
+		VBMetaSize:        be.Uint64(footer[28:]),
+	}
+	if t.AVB.OriginalImageSize > uint64(len(data)) || t.AVB.VBMetaOffset+t.AVB.VBMetaSize > uint64(len(data)) {
+		return nil, fmt.Errorf("bootimg: AVB footer points outside of the image")
+	}
+	t.VBMeta = append([]byte(nil), data[t.AVB.VBMetaOffset:t.AVB.VBMetaOffset+t.AVB.VBMetaSize]...)
+	return data[:t.AVB.OriginalImageSize], nil
+}
+
+//parseTail keeps any non-padding data left after the last section
+func (t *Trailer) parseTail(payload []byte, end int) {
+	if end >= len(payload) {
+		return
+	}
+	tail := payload[end:]
+	last := len(tail)
+	for last > 0 && tail[last-1] == 0 {
+		last--
+	}
+	if last > 0 {
+		t.Tail = append([]byte(nil), tail[:last]...)
+	}
+}
+
+//append writes the tail, vbmeta blob and AVB footer after the image
+func (t *Trailer) append(data []byte) ([]byte, error) {
+	data = append(data, t.Tail...)
+	if t.AVB == nil {
+		return data, nil
+	}
+
+	footer := *t.AVB
+	footer.OriginalImageSize = uint64(len(data))
+	footer.VBMetaOffset = uint64(align(len(data), avbBlockSize))
+	footer.VBMetaSize = uint64(len(t.VBMeta))
+
+	size := t.Size
+	if size < AVBFooterSize {
+		return nil, ErrTooLarge
+	}
+	if int64(footer.VBMetaOffset+footer.VBMetaSize) > size-AVBFooterSize {
+		return nil, ErrTooLarge
+	}
+
+	out := make([]byte, size)
+	copy(out, data)
+	copy(out[footer.VBMetaOffset:], t.VBMeta)
+
+	be := binary.BigEndian
+	f := out[size-AVBFooterSize:]
+	copy(f, AVBFooterMagic)
+	be.PutUint32(f[4:], footer.VersionMajor)
+	be.PutUint32(f[8:], footer.VersionMinor)
+	be.PutUint64(f[12:], footer.OriginalImageSize)
+	be.PutUint64(f[20:], footer.VBMetaOffset)
+	be.PutUint64(f[28:], footer.VBMetaSize)
+	*t.AVB = footer
+	return out, nil
+}
+
+//OSVersion holds the packed OS version and security patch level of a boot image
+type OSVersion uint32
+
+//NewOSVersion packs an OS version and security patch level, such as 11.0.0 and 2021-05
+func NewOSVersion(a, b, c, year, month int) OSVersion {
+	version := uint32(a&0x7f)<<14 | uint32(b&0x7f)<<7 | uint32(c&0x7f)
+	patch := uint32((year-2000)&0x7f)<<4 | uint32(month&0xf)
+	return OSVersion(version<<11 | patch)
+}
+
+//Version returns the OS version, such as 11.0.0
+func (v OSVersion) Version() (a, b, c int) {
+	version := uint32(v) >> 11
+	return int(version >> 14 & 0x7f), int(version >> 7 & 0x7f), int(version & 0x7f)
+}
+
+//PatchLevel returns the security patch level, such as 2021-05
+func (v OSVersion) PatchLevel() (year, month int) {
+	patch := uint32(v) & 0x7ff
+	return int(patch>>4) + 2000, int(patch & 0xf)
+}
+
+func (v OSVersion) String() string {
+	if v == 0 {
+		return "unset"
+	}
+	a, b, c := v.Version()
+	year, month := v.PatchLevel()
+	return fmt.Sprintf("%d.%d.%d (%04d-%02d)", a, b, c, year, month)
+}
@@ -0,0 +1,239 @@
+package bootimg
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	vendorBootV3HeaderSize = 2112
+	vendorBootV4HeaderSize = 2128
+
+	vendorBootArgsSize     = 2048
+	vendorRamdiskNameSize  = 32
+	vendorRamdiskEntrySize = 108
+)
+
+//Vendor ramdisk types, as used in vendor boot image header version 4
+const (
+	VendorRamdiskTypeNone uint32 = iota
+	VendorRamdiskTypePlatform
+	VendorRamdiskTypeRecovery
+	VendorRamdiskTypeDLKM
+)
+
+//VendorRamdisk holds a ramdisk fragment of a vendor boot image
+type VendorRamdisk struct {
+	Type    uint32
+	Name    string
+	BoardID [16]uint32
+	Data    []byte
+
+	entry []byte //raw table entry, used to preserve unknown fields
+}
+
+//VendorImage holds an Android vendor boot image
+type VendorImage struct {
+	HeaderVersion uint32
+	PageSize      uint32
+
+	KernelAddr  uint32
+	RamdiskAddr uint32
+	TagsAddr    uint32
+	DTBAddr     uint64
+
+	Name    string
+	Cmdline string
+
+	Ramdisks   []*VendorRamdisk //a single fragment for header version 3
+	DTB        []byte
+	Bootconfig []byte //header version 4
+
+	Trailer
+	header    []byte //raw header, used to preserve unknown and reserved fields
+	entrySize uint32 //size of each vendor ramdisk table entry
+}
+
+//OpenVendor reads and parses a vendor boot image from a file or block device
+func OpenVendor(path string) (*VendorImage, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseVendor(data)
+}
+
+//ParseVendor parses a vendor boot image, including any trailing data or AVB footer
+func ParseVendor(data []byte) (*VendorImage, error) {
+	if len(data) < 12 {
+		return nil, ErrTruncated
+	}
+	if string(data[:8]) != VendorBootMagic {
+		return nil, ErrMagic
+	}
+
+	img := &VendorImage{HeaderVersion: le.Uint32(data[8:])}
+	headerSize := 0
+	switch img.HeaderVersion {
+	case 3:
+		headerSize = vendorBootV3HeaderSize
+	case 4:
+		headerSize = vendorBootV4HeaderSize
+	default:
+		return nil, ErrVersion
+	}
+
+	payload, err := img.Trailer.parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < headerSize {
+		return nil, ErrTruncated
+	}
+	img.header = append([]byte(nil), payload[:headerSize]...)
+
+	h := img.header
+	img.PageSize = le.Uint32(h[12:])
+	img.KernelAddr = le.Uint32(h[16:])
+	img.RamdiskAddr = le.Uint32(h[20:])
+	img.Cmdline = cstring(h[28 : 28+vendorBootArgsSize])
+	img.TagsAddr = le.Uint32(h[2076:])
+	img.Name = cstring(h[2080 : 2080+bootNameSize])
+	img.DTBAddr = le.Uint64(h[2104:])
+	if img.PageSize == 0 {
+		return nil, fmt.Errorf("bootimg: invalid page size %d", img.PageSize)
+	}
+
+	r := &reader{data: payload, page: int(img.PageSize)}
+	r.skip(int(le.Uint32(h[2096:])))
+	ramdisks, err := r.section(le.Uint32(h[24:]))
+	if err != nil {
+		return nil, err
+	}
+	if img.DTB, err = r.section(le.Uint32(h[2100:])); err != nil {
+		return nil, err
+	}
+
+	if img.HeaderVersion == 3 {
+		img.Ramdisks = []*VendorRamdisk{{Data: ramdisks}}
+	} else {
+		table, err := r.section(le.Uint32(h[2112:]))
+		if err != nil {
+			return nil, err
+		}
+		entries := int(le.Uint32(h[2116:]))
+		img.entrySize = le.Uint32(h[2120:])
+		if img.entrySize < vendorRamdiskEntrySize || entries*int(img.entrySize) > len(table) {
+			return nil, fmt.Errorf("bootimg: invalid vendor ramdisk table")
+		}
+		for i := 0; i < entries; i++ {
+			entry := table[i*int(img.entrySize) : (i+1)*int(img.entrySize)]
+			size, offset := le.Uint32(entry[0:]), le.Uint32(entry[4:])
+			if uint64(offset)+uint64(size) > uint64(len(ramdisks)) {
+				return nil, fmt.Errorf("bootimg: vendor ramdisk %d points outside of the vendor ramdisk section", i)
+			}
+			ramdisk := &VendorRamdisk{
+				Type:  le.Uint32(entry[8:]),
+				Name:  cstring(entry[12 : 12+vendorRamdiskNameSize]),
+				Data:  append([]byte(nil), ramdisks[offset:offset+size]...),
+				entry: append([]byte(nil), entry...),
+			}
+			for j := range ramdisk.BoardID {
+				ramdisk.BoardID[j] = le.Uint32(entry[44+j*4:])
+			}
+			img.Ramdisks = append(img.Ramdisks, ramdisk)
+		}
+		if img.Bootconfig, err = r.section(le.Uint32(h[2124:])); err != nil {
+			return nil, err
+		}
+	}
+
+	img.Trailer.parseTail(payload, r.offset)
+	return img, nil
+}
+
+//Bytes returns the vendor boot image as it would be written, recalculating sizes and offsets
+func (img *VendorImage) Bytes() ([]byte, error) {
+	headerSize := 0
+	switch img.HeaderVersion {
+	case 3:
+		headerSize = vendorBootV3HeaderSize
+		if len(img.Ramdisks) > 1 {
+			return nil, fmt.Errorf("bootimg: vendor boot header version 3 only supports one ramdisk, got %d", len(img.Ramdisks))
+		}
+	case 4:
+		headerSize = vendorBootV4HeaderSize
+	default:
+		return nil, ErrVersion
+	}
+	if img.PageSize == 0 {
+		return nil, fmt.Errorf("bootimg: invalid page size %d", img.PageSize)
+	}
+
+	ramdisks := make([]byte, 0)
+	entrySize := img.entrySize
+	if entrySize < vendorRamdiskEntrySize {
+		entrySize = vendorRamdiskEntrySize
+	}
+	table := make([]byte, 0, len(img.Ramdisks)*int(entrySize))
+	for _, ramdisk := range img.Ramdisks {
+		entry := make([]byte, entrySize)
+		copy(entry, ramdisk.entry)
+		le.PutUint32(entry[0:], uint32(len(ramdisk.Data)))
+		le.PutUint32(entry[4:], uint32(len(ramdisks)))
+		le.PutUint32(entry[8:], ramdisk.Type)
+		if err := putCString(entry[12:12+vendorRamdiskNameSize], ramdisk.Name); err != nil {
+			return nil, err
+		}
+		for j, id := range ramdisk.BoardID {
+			le.PutUint32(entry[44+j*4:], id)
+		}
+		table = append(table, entry...)
+		ramdisks = append(ramdisks, ramdisk.Data...)
+	}
+
+	h := make([]byte, headerSize)
+	copy(h, img.header)
+	copy(h, VendorBootMagic)
+	le.PutUint32(h[8:], img.HeaderVersion)
+	le.PutUint32(h[12:], img.PageSize)
+	le.PutUint32(h[16:], img.KernelAddr)
+	le.PutUint32(h[20:], img.RamdiskAddr)
+	le.PutUint32(h[24:], uint32(len(ramdisks)))
+	if err := putCString(h[28:28+vendorBootArgsSize], img.Cmdline); err != nil {
+		return nil, err
+	}
+	le.PutUint32(h[2076:], img.TagsAddr)
+	if err := putCString(h[2080:2080+bootNameSize], img.Name); err != nil {
+		return nil, err
+	}
+	le.PutUint32(h[2096:], uint32(headerSize))
+	le.PutUint32(h[2100:], uint32(len(img.DTB)))
+	le.PutUint64(h[2104:], img.DTBAddr)
+	if img.HeaderVersion == 4 {
+		le.PutUint32(h[2112:], uint32(len(table)))
+		le.PutUint32(h[2116:], uint32(len(img.Ramdisks)))
+		le.PutUint32(h[2120:], entrySize)
+		le.PutUint32(h[2124:], uint32(len(img.Bootconfig)))
+	}
+
+	w := &writer{page: int(img.PageSize)}
+	w.section(h)
+	w.section(ramdisks)
+	w.section(img.DTB)
+	if img.HeaderVersion == 4 {
+		w.section(table)
+		w.section(img.Bootconfig)
+	}
+
+	return img.Trailer.append(w.buf)
+}
+
+//WriteFile writes the vendor boot image to a file
+func (img *VendorImage) WriteFile(path string) error {
+	data, err := img.Bytes()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
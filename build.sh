@@ -16,11 +16,13 @@ export WD=$PWD
 export JDMEN=$WD/menu
 export JDKERNINST=$WD/kernelinstaller
 export JDTWRPINST=$WD/twrpinstaller
+export JDBKRESTORE=$WD/backuprestorer
 
 export JDMOD=$WD/module
 export JDMENBIN=$JDMOD/bin/jdtoolbox
 export JDKERNBIN=$JDMOD/bin/krnlinst
 export JDTWRPBIN=$JDMOD/bin/twrpinst
+export JDBKRESTOREBIN=$JDMOD/bin/bkrestore
 
 # Go build the menu
 cd "$JDMEN"
@@ -34,6 +36,10 @@ go build -o "$JDKERNBIN" -ldflags="-s -w"
 cd "$JDTWRPINST"
 go build -o "$JDTWRPBIN" -ldflags="-s -w"
 
+# Go build the backup restorer
+cd "$JDBKRESTORE"
+go build -o "$JDBKRESTOREBIN" -ldflags="-s -w"
+
 # Zip the Magisk module ZIP
 cd "$JDMOD"
 zip -r -0 -v module.zip *
@@ -0,0 +1,135 @@
+//Package codec decompresses and compresses kernels and ramdisks, natively for gzip and through magiskboot for the other formats
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/JoshuaDoes/jdtoolbox/filetype"
+)
+
+var ErrUnsupported = errors.New("codec: unsupported compression format")
+
+//formats maps the compression formats magiskboot can write to its names for them
+var formats = map[filetype.Type]string{
+	filetype.Gzip:      "gzip",
+	filetype.XZ:        "xz",
+	filetype.LZMA:      "lzma",
+	filetype.BZip2:     "bzip2",
+	filetype.LZ4:       "lz4",
+	filetype.LZ4Legacy: "lz4_legacy",
+}
+
+//Magiskboot runs magiskboot for everything that isn't handled natively, such as lz4 and xz or patching cpio archives
+//
+//The path is only checked when magiskboot is needed, so installs that never need it work without it
+type Magiskboot struct {
+	Path string //path to magiskboot
+	Dir  string //directory to run magiskboot in and keep its files in
+}
+
+//Run runs magiskboot with args in its directory, failing if magiskboot can't be found
+func (mb *Magiskboot) Run(args ...string) error {
+	if _, err := os.Stat(mb.Path); err != nil {
+		return fmt.Errorf("magiskboot is needed to %s: %v", strings.Join(args, " "), err)
+	}
+	cmd := exec.Command(mb.Path, args...)
+	cmd.Dir = mb.Dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v\n%s", filepath.Base(mb.Path), strings.Join(args, " "), err, output)
+	}
+	return nil
+}
+
+//Decompress returns the decompressed data and the format it was compressed with, or the data as is if it isn't compressed
+func (mb *Magiskboot) Decompress(data []byte) ([]byte, filetype.Type, error) {
+	format := filetype.Detect(data)
+	switch {
+	case !format.IsCompressed():
+		return data, format, nil
+	case format == filetype.Gzip:
+		decompressed, err := gunzip(data)
+		return decompressed, format, err
+	}
+
+	decompressed, err := mb.file(data, "decompress")
+	return decompressed, format, err
+}
+
+//Compress returns the data compressed with format, or as is if format isn't a compression format
+func (mb *Magiskboot) Compress(data []byte, format filetype.Type) ([]byte, error) {
+	name, ok := formats[format]
+	switch {
+	case !format.IsCompressed():
+		return data, nil
+	case !ok:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, format)
+	case format == filetype.Gzip:
+		var buf bytes.Buffer
+		gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := gz.Write(data); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	return mb.file(data, "compress="+name)
+}
+
+//file runs a magiskboot command that reads one file and writes another, such as decompress
+func (mb *Magiskboot) file(data []byte, command string) ([]byte, error) {
+	in, out := filepath.Join(mb.Dir, "codec.in"), filepath.Join(mb.Dir, "codec.out")
+	defer os.Remove(in)
+	defer os.Remove(out)
+	if err := ioutil.WriteFile(in, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := mb.Run(command, in, out); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(out)
+}
+
+//gunzip decompresses every gzip stream at the start of data
+//
+//Anything else after the streams is padding, except for a dtb, such as the one of an Image.gz-dtb, which is kept after the decompressed data
+func gunzip(data []byte) ([]byte, error) {
+	r := bytes.NewReader(data)
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var decompressed bytes.Buffer
+	for {
+		gz.Multistream(false)
+		if _, err := decompressed.ReadFrom(gz); err != nil {
+			return nil, err
+		}
+		rest := data[len(data)-r.Len():]
+		if filetype.Detect(rest) != filetype.Gzip {
+			if offset := filetype.FindFDT(rest); offset >= 0 {
+				decompressed.Write(rest[offset:])
+			}
+			return decompressed.Bytes(), nil
+		}
+		if err := gz.Reset(r); err != nil {
+			return nil, err
+		}
+	}
+}
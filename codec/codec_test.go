@@ -0,0 +1,99 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/JoshuaDoes/jdtoolbox/filetype"
+)
+
+//missing returns a magiskboot that can't be found, so only native formats work
+func missing(t *testing.T) *Magiskboot {
+	dir := t.TempDir()
+	return &Magiskboot{Path: filepath.Join(dir, "magiskboot"), Dir: dir}
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	mb := missing(t)
+	cpio := append([]byte("070701"), bytes.Repeat([]byte("ramdisk "), 1000)...)
+
+	compressed, err := mb.Compress(cpio, filetype.Gzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filetype.Detect(compressed) != filetype.Gzip {
+		t.Fatalf("compressed as %s", filetype.Detect(compressed))
+	}
+	decompressed, format, err := mb.Decompress(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != filetype.Gzip || !bytes.Equal(decompressed, cpio) {
+		t.Fatalf("got %s with %d bytes, want gzip with %d bytes", format, len(decompressed), len(cpio))
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	mb := missing(t)
+	//A minimal dtb: header, an empty reservation map, the root node, the end of the structure block and an empty strings block
+	dtb := make([]byte, 76)
+	copy(dtb, []byte{0xd0, 0x0d, 0xfe, 0xed, 0, 0, 0, 76, 0, 0, 0, 56, 0, 0, 0, 72, 0, 0, 0, 40, 0, 0, 0, 17})
+	copy(dtb[56:], []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 9})
+
+	tests := []struct {
+		Name   string
+		Data   []byte
+		Want   []byte
+		Format filetype.Type
+	}{
+		{"plain", []byte("070701 plain cpio"), []byte("070701 plain cpio"), filetype.CPIO},
+		{"gzip", gzipped(t, []byte("kernel")), []byte("kernel"), filetype.Gzip},
+		{"padded", append(gzipped(t, []byte("kernel")), make([]byte, 512)...), []byte("kernel"), filetype.Gzip},
+		{"concatenated", append(gzipped(t, []byte("first ")), gzipped(t, []byte("second"))...), []byte("first second"), filetype.Gzip},
+		{"appended dtb", append(append(gzipped(t, []byte("kernel")), 0, 0, 0, 0), dtb...), append([]byte("kernel"), dtb...), filetype.Gzip},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			got, format, err := mb.Decompress(test.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != test.Format || !bytes.Equal(got, test.Want) {
+				t.Fatalf("got %s %q, want %s %q", format, got, test.Format, test.Want)
+			}
+		})
+	}
+}
+
+func TestMagiskbootOnlyWhenNeeded(t *testing.T) {
+	mb := missing(t)
+	lz4 := []byte{0x02, 0x21, 0x4c, 0x18, 0, 0, 0, 0}
+
+	if _, _, err := mb.Decompress(lz4); err == nil {
+		t.Fatal("decompressed lz4 without magiskboot")
+	}
+	if _, err := mb.Compress([]byte("070701"), filetype.LZ4Legacy); err == nil {
+		t.Fatal("compressed lz4_legacy without magiskboot")
+	}
+	if _, err := mb.Compress([]byte("070701"), filetype.Zstd); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("compressing zstd: got %v, want %v", err, ErrUnsupported)
+	}
+	if data, err := mb.Compress([]byte("070701"), filetype.CPIO); err != nil || string(data) != "070701" {
+		t.Fatalf("got %q, %v for an uncompressed format", data, err)
+	}
+}
@@ -0,0 +1,82 @@
+package filetype
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+)
+
+//Structure block tokens of a device tree blob
+const (
+	fdtBeginNode = 1
+	fdtEndNode   = 2
+	fdtProp      = 3
+	fdtNop       = 4
+	fdtEnd       = 9
+)
+
+//SplitFDT returns every device tree blob found in the data, such as those concatenated into a single dtb file or appended to a kernel
+func SplitFDT(data []byte) [][]byte {
+	fdts := make([][]byte, 0)
+	for {
+		offset := FindFDT(data)
+		if offset < 0 {
+			return fdts
+		}
+		size := int(binary.BigEndian.Uint32(data[offset+4:]))
+		fdts = append(fdts, data[offset:offset+size])
+		data = data[offset+size:]
+	}
+}
+
+//Compatible returns the compatible strings of the root node of a device tree blob, most specific first
+func Compatible(fdt []byte) []string {
+	if !validFDT(fdt) {
+		return nil
+	}
+	be := binary.BigEndian
+	offStruct, offStrings := int(be.Uint32(fdt[8:])), int(be.Uint32(fdt[12:]))
+
+	depth := 0
+	for offset := offStruct; offset+4 <= len(fdt); {
+		token := be.Uint32(fdt[offset:])
+		offset += 4
+		switch token {
+		case fdtBeginNode:
+			if depth > 0 {
+				return nil //Past the properties of the root node
+			}
+			depth++
+			end := bytes.IndexByte(fdt[offset:], 0)
+			if end < 0 {
+				return nil
+			}
+			offset = align4(offset + end + 1)
+		case fdtProp:
+			if offset+8 > len(fdt) {
+				return nil
+			}
+			size, nameOff := int(be.Uint32(fdt[offset:])), int(be.Uint32(fdt[offset+4:]))
+			offset += 8
+			if offset+size > len(fdt) || offStrings+nameOff >= len(fdt) {
+				return nil
+			}
+			name := fdt[offStrings+nameOff:]
+			if end := bytes.IndexByte(name, 0); end >= 0 {
+				name = name[:end]
+			}
+			if string(name) == "compatible" {
+				return strings.FieldsFunc(string(fdt[offset:offset+size]), func(r rune) bool { return r == 0 })
+			}
+			offset = align4(offset + size)
+		case fdtNop:
+		default: //fdtEndNode, fdtEnd or garbage
+			return nil
+		}
+	}
+	return nil
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,182 @@
+//Package filetype detects the file formats handled by the installers from their magic bytes
+package filetype
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+//Type is a file format that can be detected
+type Type int
+
+const (
+	Unknown Type = iota
+	BootImage
+	VendorBootImage
+	ARM64Image
+	ZImage
+	FDT
+	DTBO
+	Zip
+	Gzip
+	LZ4
+	LZ4Legacy
+	XZ
+	LZMA
+	BZip2
+	Zstd
+	CPIO
+	SparseImage
+)
+
+//HeaderSize is the amount of bytes needed from the start of a file to detect every type
+const HeaderSize = 64
+
+var typeNames = map[Type]string{
+	Unknown:         "unknown data",
+	BootImage:       "Android boot image",
+	VendorBootImage: "Android vendor boot image",
+	ARM64Image:      "Linux kernel ARM64 Image",
+	ZImage:          "Linux kernel ARM zImage",
+	FDT:             "Device Tree Blob",
+	DTBO:            "Android DTBO table",
+	Zip:             "Zip archive",
+	Gzip:            "gzip compressed data",
+	LZ4:             "LZ4 compressed data",
+	LZ4Legacy:       "LZ4 legacy compressed data",
+	XZ:              "XZ compressed data",
+	LZMA:            "LZMA compressed data",
+	BZip2:           "bzip2 compressed data",
+	Zstd:            "Zstandard compressed data",
+	CPIO:            "cpio archive",
+	SparseImage:     "Android sparse image",
+}
+
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return typeNames[Unknown]
+}
+
+//IsKernel returns true if the type is a bootable Linux kernel
+func (t Type) IsKernel() bool {
+	return t == ARM64Image || t == ZImage
+}
+
+//IsCompressed returns true if the type is a compressed stream that can be decompressed into another type
+func (t Type) IsCompressed() bool {
+	switch t {
+	case Gzip, LZ4, LZ4Legacy, XZ, LZMA, BZip2, Zstd:
+		return true
+	}
+	return false
+}
+
+//IsDeviceTree returns true if the type holds one or more device tree blobs
+func (t Type) IsDeviceTree() bool {
+	return t == FDT || t == DTBO
+}
+
+//magic holds a sequence of bytes that identifies a type at a given offset
+type magic struct {
+	Type   Type
+	Offset int
+	Bytes  []byte
+}
+
+//magics is checked in order, so longer and more specific sequences come first
+var magics = []magic{
+	{BootImage, 0, []byte("ANDROID!")},
+	{VendorBootImage, 0, []byte("VNDRBOOT")},
+	{ARM64Image, 56, []byte("ARM\x64")},
+	{ZImage, 36, []byte{0x18, 0x28, 0x6f, 0x01}},
+	{FDT, 0, []byte{0xd0, 0x0d, 0xfe, 0xed}},
+	{DTBO, 0, []byte{0xd7, 0xb7, 0xab, 0x1e}},
+	{SparseImage, 0, []byte{0x3a, 0xff, 0x26, 0xed}},
+	{Zip, 0, []byte("PK\x03\x04")},
+	{Zip, 0, []byte("PK\x05\x06")},
+	{XZ, 0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{CPIO, 0, []byte("070701")},
+	{CPIO, 0, []byte("070702")},
+	{CPIO, 0, []byte("070707")},
+	{LZ4, 0, []byte{0x04, 0x22, 0x4d, 0x18}},
+	{LZ4Legacy, 0, []byte{0x02, 0x21, 0x4c, 0x18}},
+	{Zstd, 0, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{BZip2, 0, []byte("BZh")},
+	{LZMA, 0, []byte{0x5d, 0x00, 0x00}},
+	{Gzip, 0, []byte{0x1f, 0x8b}},
+	{Gzip, 0, []byte{0x1f, 0x9e}}, //old gzip
+}
+
+//Detect returns the type of the data, which should hold at least the first HeaderSize bytes of a file
+func Detect(data []byte) Type {
+	for _, m := range magics {
+		end := m.Offset + len(m.Bytes)
+		if end > len(data) {
+			continue
+		}
+		if bytes.Equal(data[m.Offset:end], m.Bytes) {
+			return m.Type
+		}
+	}
+	return Unknown
+}
+
+//DetectFile returns the type of the file at path
+func DetectFile(path string) (Type, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Unknown, err
+	}
+	defer f.Close()
+
+	return DetectReader(f)
+}
+
+//DetectReader returns the type of the data read from r
+func DetectReader(r io.Reader) (Type, error) {
+	header := make([]byte, HeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Unknown, err
+	}
+	return Detect(header[:n]), nil
+}
+
+//FindFDT returns the offset of the first device tree blob appended to the data, such as in an Image.gz-dtb, or -1 if none is found
+func FindFDT(data []byte) int {
+	fdtMagic := []byte{0xd0, 0x0d, 0xfe, 0xed}
+	for offset := 0; offset < len(data); {
+		i := bytes.Index(data[offset:], fdtMagic)
+		if i < 0 {
+			return -1
+		}
+		offset += i
+		if validFDT(data[offset:]) {
+			return offset
+		}
+		offset++
+	}
+	return -1
+}
+
+//validFDT returns true if the data starts with a sane device tree blob header that fits within the data
+func validFDT(data []byte) bool {
+	if len(data) < 40 {
+		return false
+	}
+	be := binary.BigEndian
+	size := be.Uint32(data[4:])
+	offStruct, offStrings, offRsvmap := be.Uint32(data[8:]), be.Uint32(data[12:]), be.Uint32(data[16:])
+	version := be.Uint32(data[20:])
+	if size < 40 || uint64(size) > uint64(len(data)) {
+		return false
+	}
+	if offStruct >= size || offStrings >= size || offRsvmap >= size {
+		return false
+	}
+	return version >= 16 && version <= 17
+}
@@ -0,0 +1,191 @@
+package filetype
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+//at returns a header of HeaderSize bytes holding magic at offset
+func at(offset int, magic string) []byte {
+	header := make([]byte, HeaderSize)
+	copy(header[offset:], magic)
+	return header
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		t    Type
+	}{
+		{"boot", at(0, "ANDROID!"), BootImage},
+		{"boot bad magic", at(0, "ANDROID?"), Unknown},
+		{"vendor_boot", at(0, "VNDRBOOT"), VendorBootImage},
+		{"vendor_boot bad magic", at(0, "VNDRBOOX"), Unknown},
+		{"arm64 Image", at(56, "ARM\x64"), ARM64Image},
+		{"arm64 Image bad magic", at(56, "ARM\x65"), Unknown},
+		{"arm64 Image wrong offset", at(52, "ARM\x64"), Unknown},
+		{"arm64 Image truncated", at(56, "ARM\x64")[:59], Unknown},
+		{"zImage", at(36, "\x18\x28\x6f\x01"), ZImage},
+		{"zImage bad magic", at(36, "\x18\x28\x6f\x02"), Unknown},
+		{"zImage wrong offset", at(40, "\x18\x28\x6f\x01"), Unknown},
+		{"fdt", at(0, "\xd0\x0d\xfe\xed"), FDT},
+		{"fdt bad magic", at(0, "\xd0\x0d\xfe\xee"), Unknown},
+		{"dtbo", at(0, "\xd7\xb7\xab\x1e"), DTBO},
+		{"dtbo bad magic", at(0, "\xd7\xb7\xab\x1f"), Unknown},
+		{"sparse", at(0, "\x3a\xff\x26\xed"), SparseImage},
+		{"sparse bad magic", at(0, "\x3a\xff\x26\xee"), Unknown},
+		{"zip", at(0, "PK\x03\x04"), Zip},
+		{"empty zip", at(0, "PK\x05\x06"), Zip},
+		{"zip bad magic", at(0, "PK\x03\x05"), Unknown},
+		{"gzip", at(0, "\x1f\x8b\x08"), Gzip},
+		{"old gzip", at(0, "\x1f\x9e"), Gzip},
+		{"gzip bad magic", at(0, "\x1f\x8c"), Unknown},
+		{"lz4 frame", at(0, "\x04\x22\x4d\x18"), LZ4},
+		{"lz4 frame bad magic", at(0, "\x04\x22\x4d\x19"), Unknown},
+		{"lz4 legacy", at(0, "\x02\x21\x4c\x18"), LZ4Legacy},
+		{"lz4 legacy bad magic", at(0, "\x02\x21\x4c\x19"), Unknown},
+		{"xz", at(0, "\xfd7zXZ\x00"), XZ},
+		{"xz bad magic", at(0, "\xfd7zXZ\x01"), Unknown},
+		{"lzma", at(0, "\x5d\x00\x00\x80\x00"), LZMA},
+		{"lzma bad magic", at(0, "\x5d\x00\x01"), Unknown},
+		{"bzip2", at(0, "BZh9"), BZip2},
+		{"bzip2 bad magic", at(0, "BZi9"), Unknown},
+		{"zstd", at(0, "\x28\xb5\x2f\xfd"), Zstd},
+		{"zstd bad magic", at(0, "\x28\xb5\x2f\xfe"), Unknown},
+		{"cpio newc", at(0, "070701"), CPIO},
+		{"cpio newc crc", at(0, "070702"), CPIO},
+		{"cpio odc", at(0, "070707"), CPIO},
+		{"cpio bad magic", at(0, "070703"), Unknown},
+		{"empty", nil, Unknown},
+		{"zeroes", make([]byte, HeaderSize), Unknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if detected := Detect(test.data); detected != test.t {
+				t.Errorf("detected %s, expected %s", detected, test.t)
+			}
+			detected, err := DetectReader(bytes.NewReader(test.data))
+			if err != nil || detected != test.t {
+				t.Errorf("read %s (%v), expected %s", detected, err, test.t)
+			}
+		})
+	}
+}
+
+//fdt builds a device tree blob whose root node holds props, in order, and a child node holding childProps
+func fdt(props, childProps [][2]string) []byte {
+	var strs []byte
+	var structure []byte
+	u32 := func(v uint32) {
+		structure = append(structure, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	pad := func() {
+		for len(structure)%4 != 0 {
+			structure = append(structure, 0)
+		}
+	}
+	node := func(props [][2]string) {
+		for _, prop := range props {
+			u32(fdtProp)
+			u32(uint32(len(prop[1])))
+			u32(uint32(len(strs)))
+			strs = append(strs, prop[0]+"\x00"...)
+			structure = append(structure, prop[1]...)
+			pad()
+		}
+	}
+	u32(fdtBeginNode)
+	structure = append(structure, 0)
+	pad()
+	node(props)
+	u32(fdtBeginNode)
+	structure = append(structure, "cpus\x00"...)
+	pad()
+	node(childProps)
+	u32(fdtEndNode)
+	u32(fdtEndNode)
+	u32(fdtEnd)
+
+	const headerSize, rsvmapSize = 40, 16
+	offStruct := headerSize + rsvmapSize
+	offStrings := offStruct + len(structure)
+	size := offStrings + len(strs)
+	header := make([]byte, offStruct)
+	for i, v := range []uint32{0xd00dfeed, uint32(size), uint32(offStruct), uint32(offStrings), headerSize, 17, 16, 0, uint32(len(strs)), uint32(len(structure))} {
+		header[i*4], header[i*4+1], header[i*4+2], header[i*4+3] = byte(v>>24), byte(v>>16), byte(v>>8), byte(v)
+	}
+	return append(append(header, structure...), strs...)
+}
+
+var (
+	boardFDT = fdt([][2]string{{"model", "Board One\x00"}, {"compatible", "vendor,board-one\x00vendor,soc\x00"}}, nil)
+	otherFDT = fdt([][2]string{{"compatible", "vendor,board-two\x00"}}, [][2]string{{"compatible", "arm,cortex-a55\x00"}})
+)
+
+func TestFindFDT(t *testing.T) {
+	kernel := at(56, "ARM\x64")
+	badHeader := append([]byte{0xd0, 0x0d, 0xfe, 0xed}, make([]byte, 60)...) //The magic with a header that fits nothing
+	tests := []struct {
+		name   string
+		data   []byte
+		offset int
+	}{
+		{"dtb", boardFDT, 0},
+		{"appended to a kernel", append(append([]byte{}, kernel...), boardFDT...), len(kernel)},
+		{"after a bad header", append(append(append([]byte{}, kernel...), badHeader...), boardFDT...), len(kernel) + len(badHeader)},
+		{"truncated", append(append([]byte{}, kernel...), boardFDT[:len(boardFDT)-1]...), -1},
+		{"none", kernel, -1},
+		{"empty", nil, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if offset := FindFDT(test.data); offset != test.offset {
+				t.Errorf("found a dtb at %d, expected %d", offset, test.offset)
+			}
+		})
+	}
+}
+
+func TestSplitFDT(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		fdts [][]byte
+	}{
+		{"single", boardFDT, [][]byte{boardFDT}},
+		{"concatenated", append(append([]byte{}, boardFDT...), otherFDT...), [][]byte{boardFDT, otherFDT}},
+		{"padded", append(append(append(at(56, "ARM\x64"), boardFDT...), make([]byte, 13)...), otherFDT...), [][]byte{boardFDT, otherFDT}},
+		{"none", at(56, "ARM\x64"), [][]byte{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if fdts := SplitFDT(test.data); !reflect.DeepEqual(fdts, test.fdts) {
+				t.Errorf("split %d dtbs, expected %d", len(fdts), len(test.fdts))
+			}
+		})
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	tests := []struct {
+		name       string
+		fdt        []byte
+		compatible []string
+	}{
+		{"after another property", boardFDT, []string{"vendor,board-one", "vendor,soc"}},
+		{"root only", otherFDT, []string{"vendor,board-two"}},
+		{"only in a child node", fdt([][2]string{{"model", "Board\x00"}}, [][2]string{{"compatible", "arm,cortex-a55\x00"}}), nil},
+		{"no properties", fdt(nil, nil), nil},
+		{"not a dtb", at(56, "ARM\x64"), nil},
+		{"truncated", boardFDT[:len(boardFDT)-8], nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if compatible := Compatible(test.fdt); !reflect.DeepEqual(compatible, test.compatible) {
+				t.Errorf("compatible %q, expected %q", compatible, test.compatible)
+			}
+		})
+	}
+}
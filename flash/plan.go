@@ -0,0 +1,213 @@
+//Package flash writes images to partitions for the installers, and plans those writes ahead of time for dry runs
+package flash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/JoshuaDoes/jdtoolbox/backup"
+)
+
+//Write holds a single image to partition write
+type Write struct {
+	Image     string //path to the image to write
+	Partition string //path to the partition to write to
+	OldSize   int64  //size of the partition
+	OldHash   string //SHA-256 of the partition
+	NewSize   int64  //size of the image
+	NewHash   string //SHA-256 of the image
+}
+
+//Source holds where an input of the installation was chosen from
+type Source struct {
+	Name string //what the input is, such as kernel or dtb
+	Path string //path to the input as it will be used
+	From string //where the input was found, such as the zip or boot image it came from
+}
+
+//verify reads back a write, replaced by tests to fake a bad read-back
+var verify = Verify
+
+//Plan holds every write an installer makes, which are only recorded and never written when DryRun is set
+type Plan struct {
+	DryRun  bool
+	Writes  []*Write
+	Sources []*Source
+	Backups map[string]string //backup image for each partition, restored if a write to any partition fails verification
+
+	Store *backup.Store //where backups are kept
+	Tool  string        //name of the installer, recorded in backup manifests
+}
+
+//NewPlan returns a plan ready to be used, keeping backups in store
+func NewPlan(dryRun bool, store *backup.Store, tool string) *Plan {
+	return &Plan{
+		DryRun:  dryRun,
+		Writes:  make([]*Write, 0),
+		Sources: make([]*Source, 0),
+		Backups: make(map[string]string),
+		Store:   store,
+		Tool:    tool,
+	}
+}
+
+//Backup adds a partition to the backup store as name, such as boot_a, so it can be restored if flashing fails
+func (p *Plan) Backup(partition, name string) (*backup.Manifest, error) {
+	if p.DryRun {
+		return nil, nil
+	}
+	m, err := p.Store.Add(partition, name, p.Tool)
+	if err != nil {
+		return nil, err
+	}
+	p.Backups[partition] = m.ImagePath()
+	return m, nil
+}
+
+//Source records where an input was chosen from, replacing any previous source with the same name
+func (p *Plan) Source(name, path, from string) {
+	for _, source := range p.Sources {
+		if source.Name == name {
+			source.Path = path
+			source.From = from
+			return
+		}
+	}
+	p.Sources = append(p.Sources, &Source{Name: name, Path: path, From: from})
+}
+
+//Flash records a write of image to partition, and writes it unless this is a dry run
+func (p *Plan) Flash(image, partition string) error {
+	write := &Write{Image: image, Partition: partition}
+
+	var err error
+	write.OldSize, write.OldHash, err = Hash(partition, -1)
+	if err != nil {
+		return fmt.Errorf("error hashing partition [%s]: %v", partition, err)
+	}
+	write.NewSize, write.NewHash, err = Hash(image, -1)
+	if err != nil {
+		return fmt.Errorf("error hashing image [%s]: %v", image, err)
+	}
+	if write.NewSize > write.OldSize {
+		return fmt.Errorf("image [%s] is %d bytes but partition [%s] is only %d bytes", image, write.NewSize, partition, write.OldSize)
+	}
+	p.Writes = append(p.Writes, write)
+
+	if p.DryRun {
+		return nil
+	}
+
+	err = Copy(image, partition)
+	if err == nil {
+		err = verify(image, partition)
+	}
+	if err != nil {
+		return p.rollback(partition, err)
+	}
+	return nil
+}
+
+//rollback restores the backups of every partition written so far after a failed write to partition
+func (p *Plan) rollback(partition string, cause error) error {
+	msg := fmt.Sprintf("flashing [%s] failed: %v", partition, cause)
+	failed := false
+	for i := len(p.Writes) - 1; i >= 0; i-- {
+		write := p.Writes[i]
+		backup, ok := p.Backups[write.Partition]
+		if !ok {
+			msg += fmt.Sprintf("\nno backup of [%s] to restore", write.Partition)
+			failed = true
+			continue
+		}
+		err := Copy(backup, write.Partition)
+		if err == nil {
+			err = verify(backup, write.Partition)
+		}
+		if err != nil {
+			msg += fmt.Sprintf("\nrestoring [%s] from [%s] failed: %v", write.Partition, backup, err)
+			failed = true
+			continue
+		}
+		msg += fmt.Sprintf("\nrestored [%s] from [%s]", write.Partition, backup)
+	}
+	if failed {
+		msg += "\nDO NOT REBOOT until the partitions above are restored by hand!"
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+//Print describes the plan one line at a time to log
+func (p *Plan) Print(log func(string)) {
+	if p.DryRun {
+		log("Dry run plan, no partitions were written:")
+	} else {
+		log("Installation summary:")
+	}
+	for _, source := range p.Sources {
+		if source.From != "" && source.From != source.Path {
+			log(fmt.Sprintf("Using %s [%s] from %s", source.Name, source.Path, source.From))
+		} else {
+			log(fmt.Sprintf("Using %s [%s]", source.Name, source.Path))
+		}
+	}
+	if len(p.Writes) == 0 {
+		log("No partitions would be written")
+	}
+	for _, write := range p.Writes {
+		log(fmt.Sprintf("Write [%s] to [%s]", write.Image, write.Partition))
+		log(fmt.Sprintf("  old: %d bytes, sha256 %s", write.OldSize, write.OldHash))
+		log(fmt.Sprintf("  new: %d bytes, sha256 %s", write.NewSize, write.NewHash))
+	}
+}
+
+//Hash returns the size and SHA-256 of a file or block device, reading at most limit bytes if limit is 0 or above
+func Hash(path string, limit int64) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if limit >= 0 {
+		r = io.LimitReader(f, limit)
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//Copy writes the file at src onto dst, which may be a block device
+func Copy(src, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	//Only truncate regular files, block devices keep their size
+	flags := os.O_WRONLY | os.O_CREATE
+	if info, err := os.Stat(dst); err != nil || info.Mode().IsRegular() {
+		flags |= os.O_TRUNC
+	}
+	destination, err := os.OpenFile(dst, flags, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destination, source); err != nil {
+		destination.Close()
+		return err
+	}
+	if err := destination.Sync(); err != nil {
+		destination.Close()
+		return err
+	}
+	return destination.Close()
+}
@@ -0,0 +1,194 @@
+package flash
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JoshuaDoes/jdtoolbox/backup"
+)
+
+//fixture writes a file-backed partition for each name with its contents and returns their paths with a plan backing up to the same directory
+func fixture(t *testing.T, dryRun bool, partitions map[string][]byte) (*Plan, map[string]string) {
+	dir := t.TempDir()
+	paths := make(map[string]string)
+	for name, data := range partitions {
+		paths[name] = filepath.Join(dir, name)
+		if err := ioutil.WriteFile(paths[name], data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewPlan(dryRun, backup.NewStore(filepath.Join(dir, "backups"), 0), "test"), paths
+}
+
+//image writes an image to flash and returns its path
+func image(t *testing.T, data []byte) string {
+	path := filepath.Join(t.TempDir(), "new.img")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func assertContents(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("[%s] holds %q, want %q", filepath.Base(path), got, want)
+	}
+}
+
+//corruptOn makes verify flip a byte of the partition it reads back before checking it, for the first times writes to partition only
+func corruptOn(t *testing.T, partition string, times int) {
+	verify = func(image, written string) error {
+		if written == partition && times > 0 {
+			times--
+			data, err := ioutil.ReadFile(written)
+			if err != nil {
+				return err
+			}
+			data[0] ^= 0xff
+			if err := ioutil.WriteFile(written, data, 0644); err != nil {
+				return err
+			}
+		}
+		return Verify(image, written)
+	}
+	t.Cleanup(func() { verify = Verify })
+}
+
+func TestFlash(t *testing.T) {
+	plan, parts := fixture(t, false, map[string][]byte{"boot_a": []byte("old boot image")})
+	if _, err := plan.Backup(parts["boot_a"], "boot_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Flash(image(t, []byte("new boot")), parts["boot_a"]); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, parts["boot_a"], []byte("new boot"))
+	if len(plan.Writes) != 1 || plan.Writes[0].OldSize != 14 || plan.Writes[0].NewSize != 8 {
+		t.Errorf("got writes %+v", plan.Writes)
+	}
+}
+
+func TestFlashDryRun(t *testing.T) {
+	plan, parts := fixture(t, true, map[string][]byte{"boot_a": []byte("old boot image")})
+	if m, err := plan.Backup(parts["boot_a"], "boot_a"); m != nil || err != nil {
+		t.Fatalf("dry run backed up: %v, %v", m, err)
+	}
+	if err := plan.Flash(image(t, []byte("new boot")), parts["boot_a"]); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, parts["boot_a"], []byte("old boot image"))
+	if len(plan.Writes) != 1 {
+		t.Errorf("got %d writes, want 1", len(plan.Writes))
+	}
+}
+
+func TestFlashTooLarge(t *testing.T) {
+	plan, parts := fixture(t, false, map[string][]byte{"boot_a": []byte("old")})
+	if err := plan.Flash(image(t, []byte("too large")), parts["boot_a"]); err == nil {
+		t.Fatal("flashed an image larger than its partition")
+	}
+	assertContents(t, parts["boot_a"], []byte("old"))
+	if len(plan.Writes) != 0 {
+		t.Errorf("got %d writes, want 0", len(plan.Writes))
+	}
+}
+
+//TestRollback corrupts the read-back of the second write, so both partitions written so far are restored from their backups
+func TestRollback(t *testing.T) {
+	plan, parts := fixture(t, false, map[string][]byte{
+		"boot_a":        []byte("old boot image"),
+		"vendor_boot_a": []byte("old vendor boot image"),
+	})
+	for _, name := range []string{"boot_a", "vendor_boot_a"} {
+		if _, err := plan.Backup(parts[name], name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	corruptOn(t, parts["vendor_boot_a"], 1)
+
+	if err := plan.Flash(image(t, []byte("new boot")), parts["boot_a"]); err != nil {
+		t.Fatal(err)
+	}
+	err := plan.Flash(image(t, []byte("new vendor boot")), parts["vendor_boot_a"])
+	if err == nil {
+		t.Fatal("a corrupted read-back passed verification")
+	}
+	for _, want := range []string{"read back sha256", "restored [" + parts["boot_a"] + "]", "restored [" + parts["vendor_boot_a"] + "]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %q:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "DO NOT REBOOT") {
+		t.Errorf("every partition was restored, but the error says otherwise:\n%v", err)
+	}
+	assertContents(t, parts["boot_a"], []byte("old boot image"))
+	assertContents(t, parts["vendor_boot_a"], []byte("old vendor boot image"))
+}
+
+//TestRollbackWithoutBackup warns not to reboot when a written partition has no backup to restore
+func TestRollbackWithoutBackup(t *testing.T) {
+	plan, parts := fixture(t, false, map[string][]byte{"boot_a": []byte("old boot image")})
+	corruptOn(t, parts["boot_a"], 1)
+
+	err := plan.Flash(image(t, []byte("new boot")), parts["boot_a"])
+	if err == nil {
+		t.Fatal("a corrupted read-back passed verification")
+	}
+	for _, want := range []string{"no backup of [" + parts["boot_a"] + "]", "DO NOT REBOOT"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %q:\n%v", want, err)
+		}
+	}
+}
+
+//TestRollbackFails warns not to reboot when restoring a backup fails verification too
+func TestRollbackFails(t *testing.T) {
+	plan, parts := fixture(t, false, map[string][]byte{"boot_a": []byte("old boot image")})
+	if _, err := plan.Backup(parts["boot_a"], "boot_a"); err != nil {
+		t.Fatal(err)
+	}
+	corruptOn(t, parts["boot_a"], 2)
+
+	err := plan.Flash(image(t, []byte("new boot")), parts["boot_a"])
+	if err == nil {
+		t.Fatal("a corrupted read-back passed verification")
+	}
+	for _, want := range []string{"restoring [" + parts["boot_a"] + "]", "DO NOT REBOOT"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	img := image(t, []byte("new boot"))
+	tests := []struct {
+		Name      string
+		Partition []byte
+		OK        bool
+	}{
+		{"exact", []byte("new boot"), true},
+		{"padded", []byte("new boot and the rest of the partition"), true},
+		{"corrupted", []byte("new bOot and the rest of the partition"), false},
+		{"short", []byte("new"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			partition := filepath.Join(t.TempDir(), "boot_a")
+			if err := ioutil.WriteFile(partition, test.Partition, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := Verify(img, partition); (err == nil) != test.OK {
+				t.Errorf("got %v, want ok %v", err, test.OK)
+			}
+		})
+	}
+}
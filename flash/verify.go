@@ -0,0 +1,45 @@
+package flash
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+//blkflsbuf is the ioctl that flushes the buffer cache of a block device
+const blkflsbuf = 0x1261
+
+//Verify reads back the start of partition and compares it to image by SHA-256
+func Verify(image, partition string) error {
+	size, want, err := Hash(image, -1)
+	if err != nil {
+		return err
+	}
+
+	dropCache(partition)
+	readSize, got, err := Hash(partition, size)
+	if err != nil {
+		return err
+	}
+	if readSize != size {
+		return fmt.Errorf("read back %d of %d bytes from [%s]", readSize, size, partition)
+	}
+	if got != want {
+		return fmt.Errorf("read back sha256 %s from [%s] but expected %s", got, partition, want)
+	}
+	return nil
+}
+
+//dropCache flushes the buffer cache of a block device so it's read back from storage, and does nothing for other files
+func dropCache(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeDevice == 0 {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkflsbuf, 0)
+}
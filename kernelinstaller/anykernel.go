@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/JoshuaDoes/jdtoolbox/codec"
+	"github.com/JoshuaDoes/jdtoolbox/filetype"
+)
+
+//anyKernelKey matches the name of a property or shell variable assigned in anykernel.sh
+var anyKernelKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+//anyKernelEdits holds the AnyKernel3 functions that edit the ramdisk, which krnlinst can't run
+var anyKernelEdits = []string{
+	"insert_line", "replace_line", "remove_line", "replace_string", "replace_section", "remove_section",
+	"prepend_file", "insert_file", "append_file", "replace_file", "patch_fstab", "patch_cmdline", "patch_prop", "patch_ueventd",
+}
+
+//anyKernel holds what the anykernel.sh of an AnyKernel3 zip says about the devices and partitions it's meant for
+type anyKernel struct {
+	Name        string   //kernel.string
+	DeviceCheck bool     //do.devicecheck
+	Modules     bool     //do.modules
+	Devices     []string //device.name1, device.name2, ...
+	Versions    string   //supported.versions, such as 10 - 11
+	Block       string   //block, the partition the zip flashes
+	SlotDevice  string   //is_slot_device, 0, 1 or auto
+	Edits       []string //ramdisk edits made by the install section, which aren't run
+
+	dir string //where the zip was unpacked
+}
+
+//parseAnyKernel reads the anykernel.sh of an unpacked zip, returning nil if the zip isn't an AnyKernel3 zip
+func parseAnyKernel(dir string) (*anyKernel, error) {
+	f, err := os.Open(filepath.Join(dir, "anykernel.sh"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	ak := &anyKernel{Block: "auto", SlotDevice: "auto", dir: dir}
+	devices := make(map[int]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, edit := range anyKernelEdits {
+			if strings.HasPrefix(line, edit+" ") {
+				ak.Edits = append(ak.Edits, line)
+			}
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || !anyKernelKey.MatchString(kv[0]) {
+			continue
+		}
+		key := strings.ToLower(kv[0])
+		value := strings.Trim(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(kv[1]), ";")), "\"'")
+		switch {
+		case key == "kernel.string":
+			ak.Name = value
+		case key == "do.devicecheck":
+			ak.DeviceCheck = value == "1"
+		case key == "do.modules":
+			ak.Modules = value == "1"
+		case key == "supported.versions":
+			ak.Versions = value
+		case key == "block":
+			ak.Block = value
+		case key == "is_slot_device":
+			ak.SlotDevice = value
+		case strings.HasPrefix(key, "device.name"):
+			if i, err := strconv.Atoi(strings.TrimPrefix(key, "device.name")); err == nil && value != "" {
+				devices[i] = value
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	order := make([]int, 0, len(devices))
+	for i := range devices {
+		order = append(order, i)
+	}
+	sort.Ints(order)
+	for _, i := range order {
+		ak.Devices = append(ak.Devices, devices[i])
+	}
+	return ak, nil
+}
+
+//check returns every reason the zip shouldn't be installed on this device
+func (ak *anyKernel) check(hasSlots bool) []string {
+	problems := make([]string, 0)
+
+	if ak.DeviceCheck && len(ak.Devices) > 0 {
+		names := make([]string, 0)
+		for _, prop := range []string{"ro.product.device", "ro.build.product", "ro.product.vendor.device", "ro.vendor.product.device"} {
+			if name := getprop(prop); name != "" {
+				names = append(names, name)
+			}
+		}
+		matched := false
+		for _, device := range ak.Devices {
+			for _, name := range names {
+				matched = matched || strings.EqualFold(device, name)
+			}
+		}
+		if !matched {
+			problems = append(problems, fmt.Sprintf("zip is for [%s], but this device is [%s]", strings.Join(ak.Devices, ", "), strings.Join(names, ", ")))
+		}
+	}
+
+	if ak.Versions != "" {
+		release := getprop("ro.build.version.release")
+		if !supportedVersion(ak.Versions, release) {
+			problems = append(problems, fmt.Sprintf("zip supports Android %s, but this device runs Android %s", ak.Versions, release))
+		}
+	}
+
+	if block := filepath.Base(ak.Block); ak.Block != "" && block != "auto" && block != "boot" {
+		problems = append(problems, fmt.Sprintf("zip flashes [%s], but krnlinst only installs kernels to boot", ak.Block))
+	}
+
+	switch {
+	case ak.SlotDevice == "1" && !hasSlots:
+		problems = append(problems, "zip is for A/B devices, but this device has no slots")
+	case ak.SlotDevice == "0" && hasSlots:
+		problems = append(problems, "zip is for devices without slots, but this device has A/B slots")
+	}
+	return problems
+}
+
+//filter keeps the kernels and dtbs AnyKernel3 itself would flash, from the root of the zip, unless it has none of them
+func (ak *anyKernel) filter(kernels, dtbs []*candidate) ([]*candidate, []*candidate) {
+	keep := func(candidates []*candidate, match func(string) bool) []*candidate {
+		kept := make([]*candidate, 0)
+		for _, c := range candidates {
+			if !strings.Contains(c.Path, "/") && match(c.Path) {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == 0 {
+			return candidates
+		}
+		return kept
+	}
+	kernels = keep(kernels, func(path string) bool {
+		return strings.HasPrefix(path, "Image") || strings.HasPrefix(path, "zImage")
+	})
+	dtbs = keep(dtbs, func(path string) bool {
+		return path == "dtb" || path == "dtb.img"
+	})
+	return kernels, dtbs
+}
+
+//dtbo returns the path to the dtbo image the zip flashes, or an empty string if it has none
+func (ak *anyKernel) dtbo() string {
+	path := filepath.Join(ak.dir, "dtbo.img")
+	if detect(path) != filetype.DTBO {
+		return ""
+	}
+	return path
+}
+
+//supportedVersion returns true if an Android release, such as 11 or 8.1.0, is within supported, such as 10 - 11 or 9, 10
+func supportedVersion(supported, release string) bool {
+	if release == "" {
+		return false
+	}
+	if bounds := strings.SplitN(supported, "-", 2); len(bounds) == 2 {
+		low, high := strings.TrimSpace(bounds[0]), strings.TrimSpace(bounds[1])
+		return compareVersions(release, low) >= 0 && compareVersions(release, high) <= 0
+	}
+	for _, version := range strings.FieldsFunc(supported, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if compareVersions(release, version) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//compareVersions compares dotted versions up to the precision of the shortest one, so 8.1.0 equals 8.1 and 8
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.Atoi(as[i])
+		y, _ := strconv.Atoi(bs[i])
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+//getprop returns a system property, from getprop on a device or from the build.prop files under the root
+func getprop(name string) string {
+	if root == "/" {
+		if output, err := exec.Command("getprop", name).Output(); err == nil {
+			if value := strings.TrimSpace(string(output)); value != "" {
+				return value
+			}
+		}
+	}
+	for _, file := range []string{"system/build.prop", "system/system/build.prop", "vendor/build.prop"} {
+		data, err := ioutil.ReadFile(filepath.Join(root, file))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if kv := strings.SplitN(strings.TrimSpace(line), "=", 2); len(kv) == 2 && kv[0] == name {
+				return kv[1]
+			}
+		}
+	}
+	return ""
+}
+
+//overlayRamdisk adds every file in dir to a ramdisk, keeping its compression
+func overlayRamdisk(ramdisk []byte, dir string) ([]byte, error) {
+	if len(ramdisk) == 0 {
+		return nil, fmt.Errorf("boot has no ramdisk to add files to")
+	}
+	work := wd + "ramdisk"
+	if err := os.MkdirAll(work, 0755); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+
+	cpio, compression, err := decompress(ramdisk)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(work+"/ramdisk.cpio", cpio, 0644); err != nil {
+		return nil, err
+	}
+
+	cmds := []string{work + "/ramdisk.cpio"}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel := strings.TrimPrefix(path, dir+"/")
+		mode := fmt.Sprintf("%04o", info.Mode().Perm())
+		if info.IsDir() {
+			cmds = append(cmds, "mkdir "+mode+" "+rel)
+		} else {
+			cmds = append(cmds, "add "+mode+" "+rel+" "+path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := magiskboot(work, append([]string{"cpio"}, cmds...)...); err != nil {
+		return nil, err
+	}
+
+	cpio, err = ioutil.ReadFile(work + "/ramdisk.cpio")
+	if err != nil {
+		return nil, err
+	}
+	return (&codec.Magiskboot{Path: mb, Dir: work}).Compress(cpio, compression)
+}
+
+//installModules copies the modules of the zip into a Magisk module, mirroring AnyKernel3's systemless module install
+func installModules(dir, module string) error {
+	prop := "id=" + filepath.Base(module) + "\nname=AnyKernel3 modules\nversion=1\nversionCode=1\nauthor=jdtoolbox\ndescription=Kernel modules installed by krnlinst\n"
+	if err := os.MkdirAll(module, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(module, "module.prop"), []byte(prop), 0644); err != nil {
+		return err
+	}
+
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel := strings.TrimPrefix(path, dir+"/")
+		if !strings.HasPrefix(rel, "system/") {
+			rel = "system/" + rel //Magisk mounts vendor and product under system
+		}
+		dst := filepath.Join(module, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(dst, data, info.Mode().Perm())
+	})
+}
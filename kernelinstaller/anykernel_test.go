@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAnyKernel(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		ak     *anyKernel
+	}{
+		{
+			"properties",
+			"### AnyKernel3 Ramdisk Mod Script\nproperties() { '\nkernel.string=Test Kernel by someone\ndo.devicecheck=1\ndo.modules=1\ndevice.name1=alpha\ndevice.name2=\ndevice.name3=Beta\ndevice.name10=gamma\nsupported.versions=10 - 11\n'; } # end properties\n\nblock=/dev/block/bootdevice/by-name/boot;\nis_slot_device=1;\nramdisk_compression=auto;\n",
+			&anyKernel{Name: "Test Kernel by someone", DeviceCheck: true, Modules: true, Devices: []string{"alpha", "Beta", "gamma"}, Versions: "10 - 11", Block: "/dev/block/bootdevice/by-name/boot", SlotDevice: "1"},
+		},
+		{
+			"quotes",
+			"kernel.string=\"Quoted Kernel\"\nsupported.versions='9, 10'\nblock=\"boot\";\nis_slot_device='auto' ;\n",
+			&anyKernel{Name: "Quoted Kernel", Versions: "9, 10", Block: "boot", SlotDevice: "auto"},
+		},
+		{
+			"defaults",
+			"# only comments\n\n",
+			&anyKernel{Block: "auto", SlotDevice: "auto"},
+		},
+		{
+			"edits",
+			"do.devicecheck=0\ndevice.name2=beta\ninsert_line init.rc \"import /init.custom.rc\" after \"import /init.usb.rc\" \"import /init.custom.rc\";\npatch_cmdline skip_override \"\";\nwrite_boot;\n",
+			&anyKernel{Devices: []string{"beta"}, Block: "auto", SlotDevice: "auto", Edits: []string{
+				"insert_line init.rc \"import /init.custom.rc\" after \"import /init.usb.rc\" \"import /init.custom.rc\";",
+				"patch_cmdline skip_override \"\";",
+			}},
+		},
+		{
+			"not a property",
+			"if [ -f $home/Image ]; then\nfoo bar=baz\n$var=1\nfi\n",
+			&anyKernel{Block: "auto", SlotDevice: "auto"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := ioutil.WriteFile(filepath.Join(dir, "anykernel.sh"), []byte(test.script), 0644); err != nil {
+				t.Fatal(err)
+			}
+			ak, err := parseAnyKernel(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.ak.dir = dir
+			if !reflect.DeepEqual(ak, test.ak) {
+				t.Errorf("parsed %+v, expected %+v", ak, test.ak)
+			}
+		})
+	}
+
+	ak, err := parseAnyKernel(t.TempDir())
+	if ak != nil || err != nil {
+		t.Errorf("parsed %+v (%v) without an anykernel.sh, expected nothing", ak, err)
+	}
+}
+
+func TestSupportedVersion(t *testing.T) {
+	tests := []struct {
+		supported string
+		release   string
+		ok        bool
+	}{
+		{"10 - 11", "10", true},
+		{"10 - 11", "11", true},
+		{"10 - 11", "9", false},
+		{"10 - 11", "12", false},
+		{"10-11", "10.0", true},
+		{"9, 10", "10", true},
+		{"9, 10", "9", true},
+		{"9, 10", "11", false},
+		{"9 10", "10", true},
+		{"8", "8.1.0", true},
+		{"8.1", "8.0.0", false},
+		{"8.1 - 9", "8.1.0", true},
+		{"10 - 11", "", false},
+	}
+	for _, test := range tests {
+		if ok := supportedVersion(test.supported, test.release); ok != test.ok {
+			t.Errorf("Android %q within %q is %v, expected %v", test.release, test.supported, ok, test.ok)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"8.1.0", "8", 0},
+		{"8.1.0", "8.1", 0},
+		{"8", "8.1.0", 0},
+		{"8.1.0", "8.2", -1},
+		{"9", "10", -1},
+		{"11", "10", 1},
+		{"10.0.1", "10.0.0", 1},
+	}
+	for _, test := range tests {
+		if cmp := compareVersions(test.a, test.b); cmp != test.cmp {
+			t.Errorf("compared %s to %s as %d, expected %d", test.a, test.b, cmp, test.cmp)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	//getprop reads build.prop files when the root isn't /
+	saved := root
+	defer func() { root = saved }()
+	root = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "system"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "system/build.prop"), []byte("ro.product.device=alpha\nro.build.version.release=11\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		ak       *anyKernel
+		hasSlots bool
+		problems []string //what each problem starts with
+	}{
+		{"matches", &anyKernel{DeviceCheck: true, Devices: []string{"beta", "alpha"}, Versions: "10 - 11", Block: "auto", SlotDevice: "1"}, true, nil},
+		{"device name case", &anyKernel{DeviceCheck: true, Devices: []string{"ALPHA"}}, false, nil},
+		{"other device", &anyKernel{DeviceCheck: true, Devices: []string{"beta"}}, false, []string{"zip is for [beta], but this device is [alpha]"}},
+		{"device check off", &anyKernel{Devices: []string{"beta"}}, false, nil},
+		{"no devices", &anyKernel{DeviceCheck: true}, false, nil},
+		{"other version", &anyKernel{Versions: "9, 10"}, false, []string{"zip supports Android 9, 10, but this device runs Android 11"}},
+		{"empty block", &anyKernel{Block: ""}, false, nil},
+		{"boot block", &anyKernel{Block: "/dev/block/bootdevice/by-name/boot"}, false, nil},
+		{"other block", &anyKernel{Block: "/dev/block/bootdevice/by-name/recovery"}, false, []string{"zip flashes [/dev/block/bootdevice/by-name/recovery]"}},
+		{"needs slots", &anyKernel{SlotDevice: "1"}, false, []string{"zip is for A/B devices"}},
+		{"needs no slots", &anyKernel{SlotDevice: "0"}, true, []string{"zip is for devices without slots"}},
+		{"slots auto", &anyKernel{SlotDevice: "auto"}, true, nil},
+		{"everything", &anyKernel{DeviceCheck: true, Devices: []string{"beta"}, Versions: "12", Block: "vendor_boot", SlotDevice: "0"}, true, []string{"zip is for [beta]", "zip supports Android 12", "zip flashes [vendor_boot]", "zip is for devices without slots"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems := test.ak.check(test.hasSlots)
+			if len(problems) != len(test.problems) {
+				t.Fatalf("problems %q, expected %q", problems, test.problems)
+			}
+			for i, problem := range problems {
+				if !strings.HasPrefix(problem, test.problems[i]) {
+					t.Errorf("problem %q, expected %q", problem, test.problems[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	candidates := func(paths ...string) []*candidate {
+		found := make([]*candidate, 0)
+		for _, path := range paths {
+			found = append(found, &candidate{Path: path})
+		}
+		return found
+	}
+	paths := func(found []*candidate) []string {
+		kept := make([]string, 0)
+		for _, c := range found {
+			kept = append(kept, c.Path)
+		}
+		return kept
+	}
+
+	tests := []struct {
+		name                 string
+		kernels, dtbs        []string
+		keptKernels, keptDTB []string
+	}{
+		{"root images", []string{"Image.gz-dtb", "kernels/Image.gz", "zImage"}, []string{"dtb", "dtbs/board.dtb", "dtb.img"}, []string{"Image.gz-dtb", "zImage"}, []string{"dtb", "dtb.img"}},
+		{"nothing at the root", []string{"kernels/Image.gz"}, []string{"dtbs/board.dtb"}, []string{"kernels/Image.gz"}, []string{"dtbs/board.dtb"}},
+		{"other names at the root", []string{"kernel", "sub/Image"}, []string{"board.dtb"}, []string{"kernel", "sub/Image"}, []string{"board.dtb"}},
+		{"nothing", []string{}, []string{}, []string{}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kernels, dtbs := (&anyKernel{}).filter(candidates(test.kernels...), candidates(test.dtbs...))
+			if kept := paths(kernels); !reflect.DeepEqual(kept, test.keptKernels) {
+				t.Errorf("kept kernels %v, expected %v", kept, test.keptKernels)
+			}
+			if kept := paths(dtbs); !reflect.DeepEqual(kept, test.keptDTB) {
+				t.Errorf("kept dtbs %v, expected %v", kept, test.keptDTB)
+			}
+		})
+	}
+}
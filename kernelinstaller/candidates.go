@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/JoshuaDoes/jdtoolbox/filetype"
+)
+
+//candidate holds a kernel or dtb found while unpacking a zip or boot image
+type candidate struct {
+	Kind        string   `json:"kind"`                 //kernel or dtb
+	Path        string   `json:"path"`                 //path within the zip or boot image, used to pick it
+	Version     string   `json:"version,omitempty"`    //Linux version of a kernel, such as 4.19.157-perf+
+	Compression string   `json:"compression"`          //how the candidate was compressed, if at all
+	Size        int64    `json:"size"`                 //decompressed size
+	Compatible  []string `json:"compatible,omitempty"` //compatible strings of the dtbs in a dtb, or appended to a kernel
+
+	//Name and Value let the menu list the candidates as is
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	file string //path to the decompressed candidate
+}
+
+//newCandidate reads the metadata of a decompressed kernel or dtb
+func newCandidate(kind, file, path string, compression filetype.Type) (*candidate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &candidate{Kind: kind, Path: path, Value: path, Size: int64(len(data)), file: file}
+	c.Compression = "none"
+	if compression.IsCompressed() {
+		c.Compression = compression.String()
+	}
+	if kind == "kernel" {
+		c.Version = linuxVersion(data)
+	}
+	for _, fdt := range filetype.SplitFDT(data) {
+		c.Compatible = append(c.Compatible, filetype.Compatible(fdt)...)
+	}
+
+	details := make([]string, 0)
+	if c.Version != "" {
+		details = append(details, c.Version)
+	}
+	if c.Compression != "none" {
+		details = append(details, strings.Split(c.Compression, " ")[0])
+	}
+	details = append(details, fmt.Sprintf("%.1f MiB", float64(c.Size)/(1024*1024)))
+	if len(c.Compatible) > 0 {
+		details = append(details, c.Compatible[0])
+		if len(c.Compatible) > 1 {
+			details[len(details)-1] += fmt.Sprintf(" +%d", len(c.Compatible)-1)
+		}
+	}
+	c.Name = fmt.Sprintf("%s (%s)", path, strings.Join(details, ", "))
+	return c, nil
+}
+
+//linuxVersion returns the release from the Linux version banner of a kernel, or an empty string if it has none
+func linuxVersion(data []byte) string {
+	banner := []byte("Linux version ")
+	i := bytes.Index(data, banner)
+	if i < 0 {
+		return ""
+	}
+	release := data[i+len(banner):]
+	if end := bytes.IndexAny(release, " \x00\n"); end >= 0 {
+		release = release[:end]
+	}
+	return string(release)
+}
+
+//collect walks dir for kernels and dtbs, decompressing them in place
+func collect(dir string) (kernels, dtbs []*candidate) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel := strings.TrimPrefix(path, dir+"/")
+		compression := detect(path)
+		typeFile := compression
+		if compression.IsCompressed() {
+			//Magic bytes of some formats, such as LZMA, are loose enough to match scripts and tools, so a file that fails to decompress is no candidate
+			data, err := ioutil.ReadFile(path)
+			if err == nil {
+				data, _, err = decompress(data)
+			}
+			if err != nil {
+				log(fmt.Sprintf("Skipping [%s], detected as %s but failed to decompress: %v", rel, compression, err))
+				return nil
+			}
+			check(os.RemoveAll(path))
+			path += ".decompressed"
+			check(ioutil.WriteFile(path, data, 0644))
+			typeFile = filetype.Detect(data)
+		}
+
+		switch {
+		case typeFile.IsKernel():
+			c, err := newCandidate("kernel", path, rel, compression)
+			check(err)
+			log("Found kernel: " + c.Name)
+			kernels = append(kernels, c)
+		case typeFile == filetype.FDT:
+			c, err := newCandidate("dtb", path, rel, compression)
+			check(err)
+			log("Found dtb: " + c.Name)
+			dtbs = append(dtbs, c)
+		}
+		return nil
+	})
+	if err != nil {
+		check(fmt.Errorf("walking for kernel and dtb failed: %v", err))
+	}
+	return kernels, dtbs
+}
+
+//printCandidates prints the candidates of the kinds listed by --candidates as JSON for the menu
+func printCandidates(kernels, dtbs []*candidate) {
+	candidates := make([]*candidate, 0)
+	if listCandidates == "kernel" || listCandidates == "all" {
+		candidates = append(candidates, kernels...)
+	}
+	if listCandidates == "dtb" || listCandidates == "all" {
+		candidates = append(candidates, dtbs...)
+	}
+	data, err := json.MarshalIndent(candidates, "", "\t")
+	check(err)
+	fmt.Println(string(data))
+}
+
+//choose returns the candidate picked by path, or the only candidate, or the candidate matching the device's compatible strings best
+func choose(kind string, candidates []*candidate, pick string) (*candidate, error) {
+	if pick != "" && pick != "auto" {
+		for _, c := range candidates {
+			if c.Path == pick {
+				return c, nil
+			}
+		}
+		return nil, fmt.Errorf("no %s [%s] found, candidates are:\n%s", kind, pick, candidateList(candidates))
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil, nil
+	case 1:
+		return candidates[0], nil
+	}
+
+	//The first compatible string of a device is the most specific, so the lowest index wins
+	device := deviceCompatible()
+	var best *candidate
+	bestScore, tied := len(device), false
+	for _, c := range candidates {
+		score := compatibleScore(device, c.Compatible)
+		switch {
+		case score < bestScore:
+			best, bestScore, tied = c, score, false
+		case score == bestScore:
+			tied = true
+		}
+	}
+	if best == nil || tied {
+		return nil, fmt.Errorf("found %d candidates for %s and none match this device [%s] best, pick one with --pick-%s:\n%s", len(candidates), kind, strings.Join(device, ", "), kind, candidateList(candidates))
+	}
+	return best, nil
+}
+
+//compatibleScore returns the index of the first device compatible string matched by a candidate, or len(device) if none match
+func compatibleScore(device, compatible []string) int {
+	for i, d := range device {
+		for _, c := range compatible {
+			if c == d {
+				return i
+			}
+		}
+	}
+	return len(device)
+}
+
+func candidateList(candidates []*candidate) string {
+	names := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		names = append(names, "    "+c.Name)
+	}
+	return strings.Join(names, "\n")
+}
+
+//deviceCompatible returns the compatible strings of the running device, most specific first
+func deviceCompatible() []string {
+	for _, path := range []string{"proc/device-tree/compatible", "sys/firmware/devicetree/base/compatible"} {
+		data, err := ioutil.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			continue
+		}
+		return strings.FieldsFunc(string(data), func(r rune) bool { return r == 0 })
+	}
+	return nil
+}
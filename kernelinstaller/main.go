@@ -4,56 +4,133 @@ import (
 	"archive/zip"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strings"
 
 	flag "github.com/spf13/pflag"
+	"github.com/JoshuaDoes/jdtoolbox/backup"
+	"github.com/JoshuaDoes/jdtoolbox/bootimg"
+	"github.com/JoshuaDoes/jdtoolbox/codec"
+	"github.com/JoshuaDoes/jdtoolbox/filetype"
+	"github.com/JoshuaDoes/jdtoolbox/flash"
+	"github.com/JoshuaDoes/jdtoolbox/partition"
 )
 
 var (
 	wd, mb string
 	kernel, dtb string
 	boot, vendorboot string
-
-	BUFFERSIZE int64 = 4096
+	root, slot, slotChoice string
+	listCandidates, pickKernel, pickDTB string
+	force, akRamdisk, akModules bool
+	modulesDir string
+	dryRun bool
+	backups string
+	keep int
+
+	plan *flash.Plan
+	parts *partition.Table
+	targets []*target
+
+	anykernel *anyKernel
+	dtbo string //dtbo image from an AnyKernel3 zip, flashed as is
+	ramdiskDir, modulesSrc string //ramdisk files and modules from an AnyKernel3 zip
 )
 
-func init() {
+//target holds the partitions of a single slot to install to
+type target struct {
+	slot string
+	boot, vendorboot string
+	dtbo string
+	bootName, vendorbootName, dtboName string //partition names, such as boot_a
+	newBoot, newVendorboot string //repacked images, empty if the partition isn't modified
+}
+
+//setup parses the flags and finds the partitions to install to, exiting if anything is missing
+//
+//It runs from main rather than init, so the tests of this package can run without a device
+func setup() {
 	flag.StringVar(&wd, "wd", "/tmp/", "path to tmp directory for process")
-	flag.StringVar(&mb, "magiskboot", "/data/adb/magisk/magiskboot", "path to magiskboot for repacking")
+	flag.StringVar(&mb, "magiskboot", "/data/adb/magisk/magiskboot", "path to magiskboot, only required to decompress formats other than gzip and to extract ramdisks")
 	flag.StringVar(&kernel, "kernel", "", "path to kernel to install")
 	flag.StringVar(&dtb, "dtb", "", "path to dtb to install")
-	flag.StringVar(&boot, "boot", "", "path to boot partition to modify")
-	flag.StringVar(&vendorboot, "vendorboot", "", "path to vendor boot partition to modify")
+	flag.StringVar(&boot, "boot", "", "path to boot partition to modify, found by name for the current slot if not given")
+	flag.StringVar(&vendorboot, "vendorboot", "", "path to vendor boot partition to modify, found by name for the current slot if not given")
+	flag.StringVar(&slotChoice, "slot", "current", "slot to install to: current, other, both, a or b")
+	flag.StringVar(&listCandidates, "candidates", "", "print the kernel, dtb or all candidates found in the kernel zip or boot image as JSON instead of installing")
+	flag.StringVar(&pickKernel, "pick-kernel", "auto", "path of the kernel to install from the kernel zip or boot image, or auto")
+	flag.StringVar(&pickDTB, "pick-dtb", "auto", "path of the dtb to install from the kernel zip or boot image, or auto")
+	flag.BoolVar(&force, "force", false, "install AnyKernel3 zips even when anykernel.sh says they're meant for another device")
+	flag.BoolVar(&akRamdisk, "ak-ramdisk", false, "add the ramdisk files of an AnyKernel3 zip to the boot ramdisk")
+	flag.BoolVar(&akModules, "ak-modules", false, "install the modules of an AnyKernel3 zip as a Magisk module")
+	flag.StringVar(&modulesDir, "modules-dir", "/data/adb/modules/jdtoolbox-ak3", "path to the Magisk module to install AnyKernel3 modules to")
+	flag.StringVar(&root, "root", "/", "path to the root holding dev, sys and proc, used to find partitions and the current slot")
+	flag.BoolVar(&dryRun, "dry-run", false, "prepare everything in the tmp directory and print a plan without writing any partitions")
+	flag.StringVar(&backups, "backups", "/sdcard/jdtoolbox/backups", "path to backup store for partitions before they're modified")
+	flag.IntVar(&keep, "keep", 5, "backups to keep per partition and slot, <= 0 keeps all of them")
 	flag.Parse()
 
+	plan = flash.NewPlan(dryRun, backup.NewStore(backups, keep), "krnlinst")
+
 	if _, err := os.Stat(wd); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	if _, err := os.Stat(mb); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
 	if _, err := os.Stat(kernel); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	if _, err := os.Stat(boot); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+
+	var err error
+	parts, err = partition.Open(root)
+	check(err)
+	slot = parts.Slot()
+	slots, err := parts.Slots(slotChoice)
+	check(err)
+	if (boot != "" || vendorboot != "") && len(slots) > 1 {
+		check(fmt.Errorf("--boot and --vendorboot can only be used when installing to a single slot"))
 	}
-	if _, err := os.Stat(vendorboot); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+
+	for _, s := range slots {
+		t := &target{slot: s, boot: boot, vendorboot: vendorboot}
+		if t.boot == "" {
+			t.boot, err = parts.Find("boot" + s)
+			check(err)
+		}
+		if t.vendorboot == "" {
+			t.vendorboot, _ = parts.Find("vendor_boot" + s) //Only some devices have vendor boot
+		}
+		if t.dtbo, _ = parts.Find("dtbo" + s); t.dtbo != "" {
+			t.dtboName = parts.Name(t.dtbo, "dtbo"+s)
+		}
+
+		if _, err := os.Stat(t.boot); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		t.bootName = parts.Name(t.boot, "boot"+s)
+		if t.vendorboot != "" {
+			if _, err := os.Stat(t.vendorboot); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			t.vendorbootName = parts.Name(t.vendorboot, "vendor_boot"+s)
+		}
+		targets = append(targets, t)
 	}
 }
 
 func log(msg string) {
-	fmt.Println("  • " + msg)
+	if listCandidates != "" {
+		return //Only the candidates are printed
+	}
+	if msg == "" {
+		fmt.Print("\n")
+	} else {
+		fmt.Println("  • " + msg)
+	}
 }
 func check(err error) {
 	if err != nil {
@@ -63,132 +140,131 @@ func check(err error) {
 }
 
 func main() {
-	log("Backing up boot to [/sdcard/boot.img]...")
-	check(cp(boot, "/sdcard/boot.img"))
+	setup()
 
-	typeBoot := file("/sdcard/boot.img")
-	if !strings.Contains(typeBoot, "Android bootimg") {
-		check(fmt.Errorf("[%s] is not an Android bootimg: %s", boot, typeBoot))
+	if slot != "" {
+		log("Boot slot: " + slot)
+	} else {
+		log("Boot has no secondary slots")
 	}
-	log("Successfully validated boot as " + typeBoot)
-
-	if vendorboot != "" {
-		log("Backing up vendor boot to [/sdcard/vendor_boot.img]...")
-		check(cp(vendorboot, "/sdcard/vendor_boot.img"))
-
-		typeVendorBoot := file("/sdcard/vendor_boot.img")
-		if !strings.Contains(typeVendorBoot, "data") {
-			check(fmt.Errorf("[%s] is not correctly identified as data: %s", vendorboot, typeVendorBoot))
+	if dryRun {
+		log("Dry run, no partitions will be written")
+	}
+	if listCandidates == "" {
+		for _, t := range targets {
+			t.prepare()
 		}
-		log("Successfully validated vendor boot as " + typeVendorBoot)
 	}
 
-	typeKernel := file(kernel)
-	if strings.Contains(typeKernel, "Linux kernel") {
+	typeKernelImage := detect(kernel)
+	typeKernel := ""
+	switch {
+	case typeKernelImage.IsKernel():
 		//TODO: Check for architecture compatibility
 		typeKernel = "linux"
-	} else if strings.Contains(typeKernel, "Android bootimg") {
+	case typeKernelImage == filetype.BootImage:
 		typeKernel = "boot"
-	} else if strings.Contains(typeKernel, "Zip archive") {
+	case typeKernelImage == filetype.Zip:
 		typeKernel = "zip"
-	} else {
-		check(fmt.Errorf("[%s] is not an Android kernel: %s", kernel, typeKernel))
+	default:
+		check(fmt.Errorf("[%s] is not an Android kernel: %s", kernel, typeKernelImage))
 	}
-	log("Successfully validated kernel image as " + file(kernel))
+	log("Successfully validated kernel image as " + typeKernelImage.String())
+	kernelInput := kernel
 
 	if typeKernel == "linux" {
+		if listCandidates != "" {
+			c, err := newCandidate("kernel", kernel, kernel, filetype.Unknown)
+			check(err)
+			dtbs := make([]*candidate, 0)
+			if dtb != "" {
+				d, err := newCandidate("dtb", dtb, dtb, filetype.Unknown)
+				check(err)
+				dtbs = append(dtbs, d)
+			}
+			printCandidates([]*candidate{c}, dtbs)
+			return
+		}
 		log("Nothing to do for kernel")
+		plan.Source("kernel", kernel, "")
+		if dtb != "" {
+			plan.Source("dtb", dtb, "")
+		}
 	} else if typeKernel == "boot" || typeKernel == "zip" {
-		check(os.MkdirAll(wd+"kernel/tmp", 0644))
+		check(os.MkdirAll(wd+"kernel/tmp", 0755))
 
 		switch typeKernel {
 		case "boot":
 			log("Unpacking kernel image to [" + wd+"kernel]...")
-			check(magiskboot(wd+"kernel", "unpack", "-h", kernel))
+			check(unpackBoot(kernel, wd+"kernel"))
 
-			log("Unpacking kernel image ramdisk to [" + wd+"kernel/tmp]...")
-			check(magiskboot(wd+"kernel/tmp", "cpio", wd+"kernel/ramdisk.cpio", "extract"))
+			if _, err := os.Stat(wd+"kernel/ramdisk.cpio"); err == nil {
+				log("Unpacking kernel image ramdisk to [" + wd+"kernel/tmp]...")
+				check(magiskboot(wd+"kernel/tmp", "cpio", wd+"kernel/ramdisk.cpio", "extract"))
+			}
 		case "zip":
 			log("Unpacking kernel zip to [" + wd+"kernel/tmp]...")
-			archive, err := zip.OpenReader(kernel)
-			check(err)
-			defer archive.Close()
-
-			for _, f := range archive.File {
-				filePath := filepath.Join(wd+"kernel/tmp", f.Name)
+			check(unzip(kernel, wd+"kernel/tmp"))
 
-				if f.FileInfo().IsDir() {
-					os.MkdirAll(filePath, os.ModePerm)
-					continue
-				}
-
-				check(os.MkdirAll(filepath.Dir(filePath), os.ModePerm))
-				dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-				check(err)
-
-				fileInArchive, err := f.Open()
-				check(err)
+			var err error
+			anykernel, err = parseAnyKernel(wd+"kernel/tmp")
+			check(err)
+			if anykernel != nil && listCandidates == "" {
+				useAnyKernel()
+			}
+		}
 
-				_, err = io.Copy(dstFile, fileInArchive)
+		log("Walking for kernel and dtb...")
+		kernels, dtbs := collect(wd+"kernel/tmp")
+		if anykernel != nil {
+			kernels, dtbs = anykernel.filter(kernels, dtbs)
+		}
+		if typeKernel == "boot" && len(kernels) == 0 && len(dtbs) == 0 {
+			log("No kernel in ramdisk, using kernel from selected boot image")
+			c, err := newCandidate("kernel", wd+"kernel/kernel", "kernel", filetype.Unknown)
+			check(err)
+			kernels = append(kernels, c)
+			if _, err := os.Stat(wd+"kernel/dtb"); err == nil {
+				d, err := newCandidate("dtb", wd+"kernel/dtb", "dtb", filetype.Unknown)
 				check(err)
-
-				dstFile.Close()
-				fileInArchive.Close()
+				dtbs = append(dtbs, d)
 			}
 		}
+		if listCandidates != "" {
+			printCandidates(kernels, dtbs)
+			check(os.RemoveAll(wd+"kernel"))
+			return
+		}
 
-		log("Walking for kernel and dtb...")
 		kernel = ""
 		dtb = ""
-		err := filepath.Walk(wd+"kernel/tmp", func(path string, info os.FileInfo, err error) error {
-			typeFile := file(path)
-			if strings.Contains(typeFile, "compressed data") {
-				check(magiskboot(wd+"kernel", "decompress", path, path+".decompressed"))
-				check(os.RemoveAll(path))
-				path += ".decompressed"
-				typeFile = file(path)
-			}
-			if strings.Contains(typeFile, "Linux kernel") {
-				if kernel != "" {
-					check(fmt.Errorf("TODO: kernel choice not yet supported"))
-				}
-				kernel = path
-				if strings.Contains(path, "dtb") {
-					log("Found kernel+dtb: " + path)
-					return io.EOF
-				}
-				log("Found kernel: " + path)
-			}
-			if strings.Contains(typeFile, "Device Tree Blob") {
-				if dtb != "" {
-					check(fmt.Errorf("TODO: dtb choice not yet supported"))
-				}
-				dtb = path
-				log("Found dtb: " + path)
-			}
-			if kernel != "" && dtb != "" {
-				return io.EOF
-			}
-			return nil
-		})
-		if err != nil && err != io.EOF {
-			check(fmt.Errorf("walking ramdisk failed: %v", err))
+		picked, err := choose("kernel", kernels, pickKernel)
+		check(err)
+		if picked != nil {
+			kernel = picked.file
+			plan.Source("kernel", picked.Path, typeKernel+" ["+kernelInput+"]")
+			log("Picked kernel: " + picked.Name)
+		}
+		picked, err = choose("dtb", dtbs, pickDTB)
+		check(err)
+		if picked != nil {
+			dtb = picked.file
+			plan.Source("dtb", picked.Path, typeKernel+" ["+kernelInput+"]")
+			log("Picked dtb: " + picked.Name)
 		}
 
 		if kernel == "" && dtb != "" {
 			check(fmt.Errorf("finding kernel failed but found dtb, bailing"))
 		}
-		if kernel == "" && dtb == "" {
-			log("No kernel in ramdisk, using kernel from selected boot image")
-			kernel = wd+"kernel/kernel"
-			dtb = wd+"kernel/dtb"
+		if kernel == "" {
+			check(fmt.Errorf("finding kernel failed, no kernel in [%s]", kernelInput))
 		}
 
-		typeKernel = file(kernel)
-		if !strings.Contains(typeKernel, "Linux kernel") {
-			check(fmt.Errorf("[%s] is not an Android kernel: %s", kernel, typeKernel))
+		typeKernelImage = detect(kernel)
+		if !typeKernelImage.IsKernel() {
+			check(fmt.Errorf("[%s] is not an Android kernel: %s", kernel, typeKernelImage))
 		}
-		log("Successfully validated kernel as " + typeKernel)
+		log("Successfully validated kernel as " + typeKernelImage.String())
 
 		log("Cleaning up unpacked kernel image...")
 		check(os.Rename(kernel, wd+"kernel.tmp"))
@@ -204,136 +280,340 @@ func main() {
 	
 	if dtb == "" {
 		log("Trying to split kernel for dtb...")
-		check(os.MkdirAll(wd+"dtb", 0644))
-		if err := magiskboot(wd+"dtb", "split", kernel); err == nil {
+		kernelData, err := ioutil.ReadFile(kernel)
+		check(err)
+		if offset := filetype.FindFDT(kernelData); offset > 0 {
+			check(os.MkdirAll(wd+"dtb", 0755))
+			check(ioutil.WriteFile(wd+"dtb/kernel", kernelData[:offset], 0644))
+			check(ioutil.WriteFile(wd+"dtb/kernel_dtb", kernelData[offset:], 0644))
 			kernel = wd+"dtb/kernel"
 			dtb = wd+"dtb/kernel_dtb"
+			plan.Source("dtb", fmt.Sprintf("appended at offset %d", offset), "kernel ["+kernelInput+"]")
 
-			typeKernel = file(kernel)
-			if !strings.Contains(typeKernel, "Linux kernel") {
-				check(fmt.Errorf("[%s] is not an Android kernel: %s", kernel, typeKernel))
+			typeKernelImage = detect(kernel)
+			if !typeKernelImage.IsKernel() {
+				check(fmt.Errorf("[%s] is not an Android kernel: %s", kernel, typeKernelImage))
 			}
 		}
 	}
 	if dtb != "" {
-		typeDTB := file(dtb)
-		if !strings.Contains(typeDTB, "Device Tree Blob") {
+		typeDTB := detect(dtb)
+		if !typeDTB.IsDeviceTree() {
 			check(fmt.Errorf("[%s] is not a Device Tree Blob: %s", dtb, typeDTB))
 		}
-		log("Successfully validated dtb as " + typeDTB)
+		log("Successfully validated dtb as " + typeDTB.String())
 	} else {
 		log("No device tree blob found, ignoring...")
 	}
 
-	log("Unpacking boot to [" + wd+"boot]...")
-	check(os.MkdirAll(wd+"boot", 0644))
-	check(magiskboot(wd+"boot", "unpack", "-n", boot))
-
-	log("Injecting kernel into boot...")
-	check(os.Rename(kernel, wd+"boot/kernel"))
+	var dtbData []byte
 	if dtb != "" {
-		log("Injecting dtb into boot...")
-		check(os.Rename(dtb, wd+"boot/dtb"))
-	} else if file(dtb) != "" {
-		log("Removing dtb from boot...")
-		check(os.RemoveAll(wd+"boot/dtb"))
+		var err error
+		dtbData, err = ioutil.ReadFile(dtb)
+		check(err)
+	}
+	for _, t := range targets {
+		t.repack(dtbData)
+	}
+	for _, t := range targets {
+		t.flash()
 	}
 
-	log("Repacking boot...")
-	check(magiskboot(wd+"boot", "repack", "-n", boot, wd+"new.b.img"))
-	log("Cleaning up unpacked boot...")
-	if dtb != "" {
-		check(os.Rename(wd+"boot/dtb", dtb))
+	if modulesSrc != "" {
+		if !dryRun {
+			log("Installing AnyKernel3 modules to [" + modulesDir + "]...")
+			check(installModules(modulesSrc, modulesDir))
+		}
+		plan.Source("modules", modulesDir, "AnyKernel3 zip ["+kernelInput+"]")
 	}
-	check(os.RemoveAll(wd+"boot"))
 
-	typeBoot = file(wd+"new.b.img")
-	if !strings.Contains(typeBoot, "Android bootimg") {
-		check(fmt.Errorf("Failed to repack boot"))
+	log("")
+	plan.Print(log)
+}
+
+//prepare backs up and validates the partitions of the target
+func (t *target) prepare() {
+	log("Boot partition: " + t.boot + " (" + t.bootName + ")")
+	if t.vendorboot != "" {
+		log("Vendor boot partition: " + t.vendorboot + " (" + t.vendorbootName + ")")
+	} else {
+		log("No vendor boot partition found for " + t.bootName + ", ignoring...")
 	}
-	log("Successfully repacked boot as " + typeBoot)
 
-	log("Flashing boot...")
-	check(cp(wd+"new.b.img", boot))
+	if !dryRun {
+		log("Backing up " + t.bootName + " to [" + backups + "]...")
+		backupLog(plan.Backup(t.boot, t.bootName))
+	}
 
-	if vendorboot != "" && dtb != "" {
-		log("Unpacking vendor boot to [" + wd+"vendor_boot]...")
-		check(os.MkdirAll(wd+"vendor_boot", 0644))
-		check(magiskboot(wd+"vendor_boot", "unpack", "-n", vendorboot))
+	typeBoot := detect(t.boot)
+	if typeBoot != filetype.BootImage {
+		check(fmt.Errorf("[%s] is not an Android boot image: %s", t.boot, typeBoot))
+	}
+	log("Successfully validated " + t.bootName + " as " + typeBoot.String())
 
-		log("Injecting dtb into vendor boot...")
-		check(os.Rename(dtb, wd+"vendor_boot/dtb"))
+	if t.vendorboot != "" {
+		if !dryRun {
+			log("Backing up " + t.vendorbootName + " to [" + backups + "]...")
+			backupLog(plan.Backup(t.vendorboot, t.vendorbootName))
+		}
 
-		log("Repacking vendor boot...")
-		check(magiskboot(wd+"vendor_boot", "repack", "-n", vendorboot, wd+"new.vb.img"))
-		log("Cleaning up unpacked vendor boot...")
-		check(os.RemoveAll(wd+"vendor_boot"))
+		typeVendorBoot := detect(t.vendorboot)
+		if typeVendorBoot != filetype.VendorBootImage {
+			check(fmt.Errorf("[%s] is not an Android vendor boot image: %s", t.vendorboot, typeVendorBoot))
+		}
+		log("Successfully validated " + t.vendorbootName + " as " + typeVendorBoot.String())
+	}
+}
+
+//repack injects the kernel and dtb into the images of the target, without writing them yet
+func (t *target) repack(dtbData []byte) {
+	if dtbo != "" {
+		if t.dtbo == "" {
+			check(fmt.Errorf("AnyKernel3 zip has a dtbo image, but no dtbo partition was found for %s", t.bootName))
+		}
+		if !dryRun {
+			log("Backing up " + t.dtboName + " to [" + backups + "]...")
+			backupLog(plan.Backup(t.dtbo, t.dtboName))
+		}
+	}
+
+	log("Unpacking " + t.bootName + "...")
+	bootImg, err := bootimg.Open(t.boot)
+	check(err)
+	log(fmt.Sprintf("Boot image is header v%d, OS version %s", bootImg.HeaderVersion, bootImg.OSVersion))
+
+	log("Injecting kernel into " + t.bootName + "...")
+	bootImg.Kernel, err = ioutil.ReadFile(kernel)
+	check(err)
+	if dtbData != nil {
+		switch {
+		case bootImg.HeaderVersion == 2:
+			log("Injecting dtb into " + t.bootName + "...")
+			bootImg.DTB = dtbData
+		case bootImg.HeaderVersion < 2:
+			log("Appending dtb to kernel in " + t.bootName + "...")
+			bootImg.Kernel = append(bootImg.Kernel, dtbData...)
+		case t.vendorboot == "":
+			log("Boot has no dtb and no vendor boot was found, ignoring dtb...")
+		}
+	}
+
+	if ramdiskDir != "" {
+		log("Adding AnyKernel3 ramdisk files to " + t.bootName + "...")
+		bootImg.Ramdisk, err = overlayRamdisk(bootImg.Ramdisk, ramdiskDir)
+		check(err)
+	}
+
+	log("Repacking " + t.bootName + "...")
+	t.newBoot = wd + "new.b" + t.slot + ".img"
+	check(bootImg.WriteFile(t.newBoot))
+
+	typeBoot := detect(t.newBoot)
+	if typeBoot != filetype.BootImage {
+		check(fmt.Errorf("Failed to repack %s", t.bootName))
+	}
+	log("Successfully repacked " + t.bootName + " as " + typeBoot.String())
+
+	if t.vendorboot != "" && dtbData != nil {
+		log("Unpacking " + t.vendorbootName + "...")
+		vendorBootImg, err := bootimg.OpenVendor(t.vendorboot)
+		check(err)
+		log(fmt.Sprintf("Vendor boot image is header v%d", vendorBootImg.HeaderVersion))
+
+		log("Injecting dtb into " + t.vendorbootName + "...")
+		vendorBootImg.DTB = dtbData
+
+		log("Repacking " + t.vendorbootName + "...")
+		t.newVendorboot = wd + "new.vb" + t.slot + ".img"
+		check(vendorBootImg.WriteFile(t.newVendorboot))
+
+		typeVendorBoot := detect(t.newVendorboot)
+		if typeVendorBoot != filetype.VendorBootImage {
+			check(fmt.Errorf("Failed to repack %s", t.vendorbootName))
+		}
+		log("Successfully repacked " + t.vendorbootName + " as " + typeVendorBoot.String())
+	}
+}
 
-		typeVendorBoot := file(wd+"new.vb.img")
-		if !strings.Contains(typeVendorBoot, "data") {
-			check(fmt.Errorf("Failed to repack vendor boot"))
+//flash writes the repacked images of the target, or only plans to on a dry run
+func (t *target) flash() {
+	if !dryRun {
+		log("Flashing " + t.bootName + "...")
+	}
+	check(plan.Flash(t.newBoot, t.boot))
+
+	if t.newVendorboot != "" {
+		if !dryRun {
+			log("Flashing " + t.vendorbootName + "...")
 		}
-		log("Successfully repacked vendor boot as " + typeVendorBoot)
+		check(plan.Flash(t.newVendorboot, t.vendorboot))
+	}
 
-		log("Flashing vendor boot...")
-		check(cp(wd+"new.vb.img", vendorboot))
+	if dtbo != "" {
+		if !dryRun {
+			log("Flashing " + t.dtboName + "...")
+		}
+		check(plan.Flash(dtbo, t.dtbo))
 	}
 }
 
+//magiskboot runs magiskboot in dir, failing only then if it can't be found
 func magiskboot(dir string, args ...string) error {
-	return run(mb, dir, args...)
+	return (&codec.Magiskboot{Path: mb, Dir: dir}).Run(args...)
 }
 
-func run(prog, dir string, args ...string) error {
-	cmd := exec.Command(prog, args...)
-	//cmd.Stdout = os.Stdout
-	cmd.Stdout = os.NewFile(0, os.DevNull)
-	//cmd.Stderr = os.Stderr
-	cmd.Stderr = os.NewFile(0, os.DevNull)
-	cmd.Stdin = os.Stdin
-	cmd.Dir = dir
-	return cmd.Run()
+//decompress returns the decompressed data and the format it was compressed with, gzip natively and anything else through magiskboot
+func decompress(data []byte) ([]byte, filetype.Type, error) {
+	return (&codec.Magiskboot{Path: mb, Dir: wd}).Decompress(data)
 }
 
-func cp(src, dst string) error {
-	source, err := os.Open(src)
+//unpackBoot writes the kernel, dtb and ramdisk of a boot image to dir, decompressing them the same way magiskboot unpack would
+func unpackBoot(path, dir string) error {
+	img, err := bootimg.Open(path)
 	if err != nil {
 		return err
 	}
-	defer source.Close()
 
-	destination, err := os.Create(dst)
-	if err != nil {
+	if err := writeDecompressed(dir+"/kernel", img.Kernel); err != nil {
 		return err
 	}
-	defer destination.Close()
+	if len(img.DTB) > 0 {
+		if err := ioutil.WriteFile(dir+"/dtb", img.DTB, 0644); err != nil {
+			return err
+		}
+	}
+	if len(img.Ramdisk) > 0 {
+		if err := writeDecompressed(dir+"/ramdisk.cpio", img.Ramdisk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+//unzip extracts a zip to dir, refusing entries that would land outside of it and skipping anything that isn't a file or directory, such as symlinks
+//
+//Kernel zips come from anywhere and are extracted as root, so an entry such as ../../data/adb/service.d/run.sh must never be written
+func unzip(path, dir string) error {
+	archive, err := zip.OpenReader(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer archive.Close()
 
-	buf := make([]byte, BUFFERSIZE)
-	for {
-		n, err := source.Read(buf)
-		if err != nil && err != io.EOF {
-			return err
-		}
-		if n == 0 {
-			break
+	dir = filepath.Clean(dir)
+	for _, f := range archive.File {
+		filePath := filepath.Join(dir, f.Name)
+		if filePath != dir && !strings.HasPrefix(filePath, dir+string(os.PathSeparator)) {
+			return fmt.Errorf("zip entry [%s] is outside of the zip", f.Name)
 		}
 
-		if _, err := destination.Write(buf[:n]); err != nil {
+		mode := f.Mode()
+		if mode.IsDir() {
+			if err := os.MkdirAll(filePath, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			log("Skipping [" + f.Name + "], only files and directories are unpacked")
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 			return err
 		}
+		if err := unzipFile(f, filePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//unzipFile writes a single zip entry to path, keeping only its permission bits
+func unzipFile(f *zip.File, path string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
 	}
-	return err
+	defer src.Close()
+
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
 }
 
-func file(path string) string {
-	fileType, err := exec.Command(wd+"/bin/file-" + runtime.GOARCH, "--magic-file", wd+"bin/magic.mgc", path).Output()
+//writeDecompressed writes data to path, decompressing it first if it's compressed
+func writeDecompressed(path string, data []byte) error {
+	data, _, err := decompress(data)
 	if err != nil {
-		return ""
+		return err
 	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
 
-	return string(fileType[len(path)+2:len(fileType)-1])
+//detect returns the type of a file, or filetype.Unknown if it can't be read
+func detect(path string) filetype.Type {
+	fileType, err := filetype.DetectFile(path)
+	if err != nil {
+		return filetype.Unknown
+	}
+	return fileType
+}
+
+//backupLog logs where a backup was kept, exiting if it failed
+func backupLog(m *backup.Manifest, err error) {
+	check(err)
+	log("Backed up " + m.Name() + " to [" + m.ImagePath() + "]")
+}
+
+//useAnyKernel checks an AnyKernel3 zip against this device and sets aside the dtbo, ramdisk files and modules it installs
+func useAnyKernel() {
+	log("Found AnyKernel3 zip: " + anykernel.Name)
+	problems := anykernel.check(parts.HasSlots())
+	for _, problem := range problems {
+		log("AnyKernel3 check failed: " + problem)
+	}
+	if len(problems) > 0 {
+		if !force {
+			check(fmt.Errorf("refusing to install [%s] on this device, use --force to install it anyway", kernel))
+		}
+		log("Forced to install anyway")
+	}
+	if len(anykernel.Edits) > 0 {
+		log(fmt.Sprintf("anykernel.sh makes %d ramdisk edits with shell commands, which are not applied", len(anykernel.Edits)))
+	}
+
+	if path := anykernel.dtbo(); path != "" {
+		check(os.Rename(path, wd+"dtbo.tmp"))
+		dtbo = wd+"dtbo.tmp"
+		plan.Source("dtbo", "dtbo.img", "AnyKernel3 zip ["+kernel+"]")
+	}
+	if akRamdisk {
+		if info, err := os.Stat(wd+"kernel/tmp/ramdisk"); err == nil && info.IsDir() {
+			check(os.RemoveAll(wd+"ramdisk.tmp"))
+			check(os.Rename(wd+"kernel/tmp/ramdisk", wd+"ramdisk.tmp"))
+			ramdiskDir = wd+"ramdisk.tmp"
+			plan.Source("ramdisk files", "ramdisk/", "AnyKernel3 zip ["+kernel+"]")
+		} else {
+			log("AnyKernel3 zip has no ramdisk files, ignoring...")
+		}
+	}
+	if akModules {
+		if !anykernel.Modules {
+			log("anykernel.sh doesn't install modules, installing them anyway")
+		}
+		if info, err := os.Stat(wd+"kernel/tmp/modules"); err == nil && info.IsDir() {
+			check(os.RemoveAll(wd+"modules.tmp"))
+			check(os.Rename(wd+"kernel/tmp/modules", wd+"modules.tmp"))
+			modulesSrc = wd+"modules.tmp"
+		} else {
+			log("AnyKernel3 zip has no modules, ignoring...")
+		}
+	}
 }
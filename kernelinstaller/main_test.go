@@ -0,0 +1,88 @@
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//writeZip writes a zip holding a file for each name, where names ending in / are directories and a target makes a symlink
+func writeZip(t *testing.T, entries map[string]string, symlinks map[string]string) string {
+	path := filepath.Join(t.TempDir(), "kernel.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	archive := zip.NewWriter(f)
+	add := func(name, data string, mode os.FileMode) {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		header.SetMode(mode)
+		w, err := archive.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range entries {
+		mode := os.FileMode(0644)
+		if name[len(name)-1] == '/' {
+			mode = os.ModeDir | 0755
+		}
+		add(name, data, mode)
+	}
+	for name, target := range symlinks {
+		add(name, target, os.ModeSymlink|0777)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUnzip(t *testing.T) {
+	listCandidates = "all" //Keep log quiet
+	defer func() { listCandidates = "" }()
+
+	dir := t.TempDir()
+	zip := writeZip(t, map[string]string{
+		"anykernel.sh":            "block=boot;\n",
+		"ramdisk/":                "",
+		"modules/vendor/lib/a.ko": "module",
+	}, map[string]string{
+		"link": "/data/adb",
+	})
+	if err := unzip(zip, dir); err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{"anykernel.sh": "block=boot;\n", "modules/vendor/lib/a.ko": "module"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil || string(data) != expected {
+			t.Errorf("unpacked %s as %q (%v), expected %q", path, data, err, expected)
+		}
+	}
+	for _, path := range []string{"ramdisk", "modules/vendor/lib"} {
+		if info, err := os.Stat(filepath.Join(dir, path)); err != nil || info.Mode().Perm() != 0755 {
+			t.Errorf("unpacked directory %s as %v (%v), expected it to be traversable", path, info, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "link")); !os.IsNotExist(err) {
+		t.Errorf("unpacked a symlink entry")
+	}
+
+	for _, name := range []string{"../escaped", "ramdisk/../../escaped", "../" + filepath.Base(dir) + "-escaped"} {
+		work := filepath.Join(t.TempDir(), "tmp")
+		if err := unzip(writeZip(t, map[string]string{name: "escaped"}, nil), work); err == nil {
+			t.Errorf("unpacked %s outside of the work dir", name)
+		}
+		matches, _ := filepath.Glob(filepath.Join(filepath.Dir(work), "*escaped"))
+		if len(matches) > 0 {
+			t.Errorf("wrote %v for %s", matches, name)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+//Args splits a command line into arguments like a POSIX shell would, with every var expanding into a single argument
+//
+//Single quotes keep everything literally, double quotes keep spaces and still expand vars, and a backslash escapes the next character
+func (me *MenuEngine) Args(line string) ([]string, error) {
+	return splitArgs(line, me.lookup)
+}
+
+//Argv returns the arguments of an exec or pick item, from its argv array if it has one or by splitting its action otherwise
+func (me *MenuEngine) Argv(item *MenuItem) ([]string, error) {
+	if len(item.Argv) == 0 {
+		return me.Args(item.Action)
+	}
+	argv := make([]string, len(item.Argv))
+	for i, arg := range item.Argv {
+		argv[i] = expandVars(arg, me.lookup)
+	}
+	return argv, nil
+}
+
+//splitArgs splits a command line into arguments, see Args
+func splitArgs(line string, lookup func(string) (string, bool)) ([]string, error) {
+	args := make([]string, 0)
+	arg := ""
+	inArg := false //an argument was started, even if it's still empty such as ""
+	quote := rune(0)
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg += string(r)
+			}
+		case r == '\\':
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("unfinished escape at the end of: %s", line)
+			}
+			i++
+			if quote == '"' && !strings.ContainsRune("\"\\$`", runes[i]) {
+				arg += "\\" //Double quotes only escape what they would otherwise treat specially
+			}
+			arg += string(runes[i])
+			inArg = true
+		case r == '$':
+			value, n, _ := expandVar(runes[i+1:], lookup)
+			if n == 0 {
+				arg += "$" //Keep unknown vars as they are, such as $? before the explorer replaces it
+				inArg = true
+				continue
+			}
+			arg += value
+			inArg = inArg || value != "" //An empty var outside quotes is no argument at all
+			i += n
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg += string(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg)
+				arg, inArg = "", false
+			}
+		default:
+			arg += string(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("missing closing %c quote in: %s", quote, line)
+	}
+	if inArg {
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+//splitWords splits a line into words where Args would split it into arguments, keeping each word as written with its quotes, escapes and vars
+//
+//Joining the words with spaces gives a line that Args splits like the words would have been, such as once the vars they hold are known
+func splitWords(line string) ([]string, error) {
+	words := make([]string, 0)
+	start := -1 //where the word being read starts, or -1 between words
+	quote := rune(0)
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if quote == 0 && unicode.IsSpace(r) {
+			if start >= 0 {
+				words = append(words, string(runes[start:i]))
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			}
+		case r == '\\':
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("unfinished escape at the end of: %s", line)
+			}
+			i++
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("missing closing %c quote in: %s", quote, line)
+	}
+	if start >= 0 {
+		words = append(words, string(runes[start:]))
+	}
+	return words, nil
+}
+
+//shellQuote quotes an argument so Args reads it back as is
+func shellQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`;&|<>()*?[]#~") {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+//shellJoin joins arguments into a command line that Args splits back into the same arguments
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//testLookup looks vars up from a fixed set
+func testLookup(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+var testVars = map[string]string{
+	"a":     "one",
+	"ab":    "two",
+	"space": "a b",
+	"empty": "",
+	"quote": `it's "quoted"`,
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		Name string
+		Line string
+		Args []string
+		Err  bool
+	}{
+		{"words", "flash  boot_a\tboot.img ", []string{"flash", "boot_a", "boot.img"}, false},
+		{"empty", "", []string{}, false},
+		{"single quotes", `echo 'a  b' '$a' '\n'`, []string{"echo", "a  b", "$a", `\n`}, false},
+		{"double quotes", `echo "a  b" "$a" "\n" "\"\$\\"`, []string{"echo", "a  b", "one", `\n`, `"$\`}, false},
+		{"nested quotes", `echo "it's" 'say "hi"' "a'b"'c"d'`, []string{"echo", "it's", `say "hi"`, `a'bc"d`}, false},
+		{"escapes", `echo a\ b \'c \$a \\`, []string{"echo", "a b", "'c", "$a", `\`}, false},
+		{"empty quotes", `echo "" ''`, []string{"echo", "", ""}, false},
+		{"var is one argument", "echo $space x$a", []string{"echo", "a b", "xone"}, false},
+		{"empty var is no argument", "echo $empty x", []string{"echo", "x"}, false},
+		{"empty var in quotes", `echo "$empty" x`, []string{"echo", "", "x"}, false},
+		{"var with quotes", "echo $quote", []string{"echo", `it's "quoted"`}, false},
+		{"unknown var", "echo $nope $? $", []string{"echo", "$nope", "$?", "$"}, false},
+		{"braces and default", "echo ${a}b ${nope:-x y}", []string{"echo", "oneb", "x y"}, false},
+		{"unterminated single quote", "echo 'a b", nil, true},
+		{"unterminated double quote", `echo "a b`, nil, true},
+		{"quote left open by escape", `echo "a\"`, nil, true},
+		{"trailing backslash", `echo a\`, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			args, err := splitArgs(test.Line, testLookup(testVars))
+			if test.Err {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(args, test.Args) {
+				t.Errorf("args %q, expected %q", args, test.Args)
+			}
+		})
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Line  string
+		Words []string
+		Err   bool
+	}{
+		{"words", " set  a\t&& b ", []string{"set", "a", "&&", "b"}, false},
+		{"empty", "", []string{}, false},
+		{"quotes kept", `probe "a && b" 'c || d'`, []string{"probe", `"a && b"`, `'c || d'`}, false},
+		{"nested quotes", `x "it's" 'say "hi"'`, []string{"x", `"it's"`, `'say "hi"'`}, false},
+		{"escapes kept", `a\ b \"c`, []string{`a\ b`, `\"c`}, false},
+		{"vars kept", "$a ${space} x$ab", []string{"$a", "${space}", "x$ab"}, false},
+		{"unterminated quote", `probe "a`, nil, true},
+		{"trailing backslash", `probe a\`, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			words, err := splitWords(test.Line)
+			if test.Err {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", words)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(words, test.Words) {
+				t.Errorf("words %q, expected %q", words, test.Words)
+			}
+
+			//Splitting the words into arguments must match splitting the line
+			joined, err := splitArgs(strings.Join(words, " "), testLookup(testVars))
+			if err != nil {
+				t.Fatal(err)
+			}
+			line, err := splitArgs(test.Line, testLookup(testVars))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(joined, line) {
+				t.Errorf("joined words split into %q, line split into %q", joined, line)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		Arg    string
+		Quoted string
+	}{
+		{"boot.img", "boot.img"},
+		{"/sdcard/a-b_c+d=e,f@g:h", "/sdcard/a-b_c+d=e,f@g:h"},
+		{"", "''"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{`"$a"`, `'"$a"'`},
+		{`a\b`, `'a\b'`},
+		{"a;b&c|d", "'a;b&c|d'"},
+		{"*.img", "'*.img'"},
+		{"~/x", "'~/x'"},
+		{"a\nb", "'a\nb'"},
+	}
+	for _, test := range tests {
+		if quoted := shellQuote(test.Arg); quoted != test.Quoted {
+			t.Errorf("shellQuote(%q) = %q, expected %q", test.Arg, quoted, test.Quoted)
+		}
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	tests := [][]string{
+		{"flash", "boot_a", "/sdcard/boot.img"},
+		{"echo", "", "a b", "it's", `say "hi"`},
+		{"echo", "$a", "${ab}", "$$", `\`, `'\''`},
+		{"echo", "tab\there", "new\nline", "ünï • côdé"},
+		{},
+	}
+	for _, args := range tests {
+		line := shellJoin(args)
+		split, err := splitArgs(line, testLookup(testVars))
+		if err != nil {
+			t.Errorf("shellJoin(%q) = %q: %v", args, line, err)
+			continue
+		}
+		if !reflect.DeepEqual(split, args) {
+			t.Errorf("shellJoin(%q) = %q, split back into %q", args, line, split)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/JoshuaDoes/jdtoolbox/backup"
+)
+
+const (
+	backupsMenu       = "INTERNAL_BACKUPS"        //menu ID of the backups in a backup store
+	backupDetailsMenu = "INTERNAL_BACKUP_DETAILS" //menu ID of the details of a backup, with an entry to restore it
+)
+
+//Backups lists the backups in a backup store, each with a menu of details and an entry to restore it by passing its manifest to bin
+//
+//The list is read again every time it's returned to, such as after a backup was restored
+func (me *MenuEngine) Backups(storeDir, bin string) {
+	backups := &MenuItemList{}
+	backups.reload = func(backups *MenuItemList) {
+		backups.Title = "Backups - " + storeDir
+		backups.Items = make([]*MenuItem, 0)
+
+		manifests, err := backup.NewStore(storeDir, 0).List()
+		if err != nil {
+			backups.AddItem("Path "+storeDir+" has unreadable backups!", "note", fmt.Sprintf("%v", err))
+		} else if len(manifests) == 0 {
+			backups.AddItem("No backups yet, they're made before every install", "note", "")
+		}
+		for _, m := range manifests {
+			name := fmt.Sprintf("%s - %s (%s, %.1f MiB)", m.Name(), m.Time.Format("2006-01-02 15:04"), m.Tool, float64(m.Size)/(1024*1024))
+			backups.Items = append(backups.Items, &MenuItem{Name: name, Type: "backup", Argv: []string{m.Path(), bin}})
+		}
+	}
+	backups.reload(backups)
+
+	me.AddMenu(backupsMenu, backups)
+	me.ChangeMenu(backupsMenu)
+}
+
+//Backup lists the details of the backup with the manifest at path, with an entry to restore it by passing its manifest to bin
+func (me *MenuEngine) Backup(path, bin string) {
+	details := &MenuItemList{}
+	details.reload = func(details *MenuItemList) {
+		details.Title = "Backup - " + path
+		details.Items = make([]*MenuItem, 0)
+		m, err := backup.Load(path)
+		if err != nil {
+			details.AddItem("Backup no longer exists!", "note", fmt.Sprintf("%v", err))
+			return
+		}
+
+		details.Title = "Backup - " + m.Name() + " at " + m.Time.Format("2006-01-02 15:04:05")
+		details.AddItem("Partition: "+m.Name(), "note", "")
+		details.AddItem("Device: "+m.Device, "note", "")
+		details.AddItem(fmt.Sprintf("Size: %d bytes", m.Size), "note", "")
+		details.AddItem("SHA-256: "+m.SHA256, "note", "")
+		if m.Kernel != "" {
+			details.AddItem("Kernel: "+m.Kernel, "note", "")
+		}
+		details.AddItem("Made by: "+m.Tool, "note", "")
+		details.AddItem("", "divider", "1")
+		details.AddItem("Restore this backup ...", "exec Backup restored!", strings.Replace(bin, "$?", shellQuote(m.Path()), -1))
+		details.Items[len(details.Items)-1].Confirm = "Restore " + m.Name() + " from this backup?"
+	}
+	details.reload(details)
+
+	me.AddMenu(backupDetailsMenu, details)
+	me.ChangeMenu(backupDetailsMenu)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+//probeTimeout is how long a probe command may run before it counts as failed
+const probeTimeout = 5 * time.Second
+
+//condition holds a single check of an item condition, such as set name or name == value
+type condition struct {
+	negate bool
+	check  string   //set, unset, ==, !=, exists or probe
+	args   []string //var name and value, path, or command line
+}
+
+//parseConditions parses an item condition into alternatives joined by ||, each holding checks joined by && that must all pass
+//
+//Checks are set NAME, unset NAME, NAME == VALUE, NAME != VALUE, exists PATH and probe COMMAND [ARGS...], each can be negated with a leading !
+//The condition is split into words like Args would split it, so only a && or || standing on its own joins checks, and one within quotes is left to the check
+func parseConditions(expr string) ([][]*condition, error) {
+	words, err := splitWords(expr)
+	if err != nil {
+		return nil, err
+	}
+	alternatives := make([][]*condition, 0)
+	conditions := make([]*condition, 0)
+	check := make([]string, 0)
+	for i := 0; i <= len(words); i++ {
+		if i < len(words) && words[i] != "&&" && words[i] != "||" {
+			check = append(check, words[i])
+			continue
+		}
+		c, err := parseCondition(check)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, c)
+		check = make([]string, 0)
+		if i == len(words) || words[i] == "||" {
+			alternatives = append(alternatives, conditions)
+			conditions = make([]*condition, 0)
+		}
+	}
+	return alternatives, nil
+}
+
+//parseCondition parses the words of a single check, keeping values, paths and command lines as written so their vars expand when the check runs
+func parseCondition(words []string) (*condition, error) {
+	check := strings.Join(words, " ")
+	c := &condition{}
+	if len(words) > 0 && strings.HasPrefix(words[0], "!") {
+		c.negate = true
+		words = append([]string{strings.TrimPrefix(words[0], "!")}, words[1:]...)
+		if words[0] == "" {
+			words = words[1:]
+		}
+	}
+	switch {
+	case len(words) == 0:
+		return nil, fmt.Errorf("empty check in condition")
+	case words[0] == "set" || words[0] == "unset":
+		if len(words) != 2 {
+			return nil, fmt.Errorf("%s takes a single var name: %s", words[0], check)
+		}
+		c.check, c.args = words[0], words[1:]
+	case words[0] == "exists" || words[0] == "probe":
+		if len(words) < 2 {
+			return nil, fmt.Errorf("%s is missing what to check: %s", words[0], check)
+		}
+		c.check, c.args = words[0], words[1:]
+	case len(words) >= 2 && (words[1] == "==" || words[1] == "!="):
+		c.check, c.args = words[1], append([]string{words[0]}, words[2:]...)
+	default:
+		return nil, fmt.Errorf("unknown check: %s", check)
+	}
+	return c, nil
+}
+
+//Condition returns true if an item condition passes, where a condition that can't be parsed or is still waiting on a probe never passes
+func (me *MenuEngine) Condition(expr string) bool {
+	passed, _ := me.condition(expr)
+	return passed
+}
+
+//condition returns true if an item condition passes, and whether it's pending on a probe that's still running
+//
+//Every check runs, so the probes of a condition all start at once rather than one after another
+func (me *MenuEngine) condition(expr string) (bool, bool) {
+	alternatives, err := parseConditions(expr)
+	if err != nil {
+		return false, false
+	}
+	passed, pending := false, false
+	for _, conditions := range alternatives {
+		all := true
+		for _, c := range conditions {
+			result, waiting := me.check(c)
+			all = all && result
+			pending = pending || waiting
+		}
+		passed = passed || all
+	}
+	return passed && !pending, pending
+}
+
+//check returns the result of a single check, and whether it's pending on a probe that's still running
+func (me *MenuEngine) check(c *condition) (bool, bool) {
+	result := false
+	switch c.check {
+	case "set":
+		result = me.Environment[c.args[0]] != ""
+	case "unset":
+		result = me.Environment[c.args[0]] == ""
+	case "==", "!=":
+		value, err := me.Args(strings.Join(c.args[1:], " "))
+		if err != nil {
+			return false, false
+		}
+		result = (me.Environment[c.args[0]] == strings.Join(value, " ")) == (c.check == "==")
+	case "exists":
+		if path, err := me.Args(strings.Join(c.args, " ")); err == nil && len(path) == 1 {
+			_, err := os.Stat(path[0])
+			result = err == nil
+		}
+	case "probe":
+		passed, pending := me.probe(strings.Join(c.args, " "))
+		if pending {
+			return false, true
+		}
+		result = passed
+	}
+	return result != c.negate, false
+}
+
+//probe holds the result of a probe condition, which is pending until its command exits
+type probe struct {
+	done   bool
+	passed bool
+}
+
+//probe returns true if a command line exits successfully, and whether it's still running
+//
+//It runs once in the background until another menu is entered, rendering the menu again once it exits
+func (me *MenuEngine) probe(cmdLine string) (bool, bool) {
+	args, err := me.Args(cmdLine)
+	if err != nil || len(args) == 0 {
+		return false, false
+	}
+	key := shellJoin(args)
+	if p, ok := me.probes[key]; ok {
+		return p.passed, !p.done
+	}
+
+	if me.probes == nil {
+		me.probes = make(map[string]*probe)
+	}
+	p := &probe{}
+	me.probes[key] = p
+	me.Command(args, ioutil.Discard, ioutil.Discard, nil, probeTimeout, func(code int, err error) {
+		p.done, p.passed = true, err == nil && code == 0
+		if me.probes[key] == p && me.LoadedMenu != execMenu {
+			me.render() //Still the menu the probe was run for
+		}
+	})
+	return false, true
+}
+
+//visible returns true if an item is shown, which it is while its condition is pending so it can be shown as checking
+func (me *MenuEngine) visible(item *MenuItem) bool {
+	if item.VisibleIf == "" {
+		return true
+	}
+	passed, pending := me.condition(item.VisibleIf)
+	return passed || pending
+}
+
+//enabled returns true if an item can be selected when shown
+func (me *MenuEngine) enabled(item *MenuItem) bool {
+	return item.EnabledIf == "" || me.Condition(item.EnabledIf)
+}
+
+//checking returns true if an item is waiting on a probe to know whether it's shown or enabled
+func (me *MenuEngine) checking(item *MenuItem) bool {
+	checking := false
+	for _, expr := range []string{item.VisibleIf, item.EnabledIf} {
+		if _, pending := me.condition(expr); expr != "" && pending {
+			checking = true //Keep going, so the probes of both conditions start at once
+		}
+	}
+	return checking
+}
+
+//selectable returns true if the item cursor can rest on an item
+func (me *MenuEngine) selectable(item *MenuItem) bool {
+	return item.Type != "divider" && me.visible(item) && me.enabled(item) && !me.checking(item)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConditions(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Expr         string
+		Alternatives [][]condition
+		Err          bool
+	}{
+		{"set", "set verbose", [][]condition{{{false, "set", []string{"verbose"}}}}, false},
+		{"unset", "unset verbose", [][]condition{{{false, "unset", []string{"verbose"}}}}, false},
+		{"equals", "$SLOT == _a", [][]condition{{{false, "==", []string{"$SLOT", "_a"}}}}, false},
+		{"not equals", "$SLOT != _a", [][]condition{{{false, "!=", []string{"$SLOT", "_a"}}}}, false},
+		{"equals nothing", "$SLOT ==", [][]condition{{{false, "==", []string{"$SLOT"}}}}, false},
+		{"exists", "exists /sdcard/boot.img", [][]condition{{{false, "exists", []string{"/sdcard/boot.img"}}}}, false},
+		{"probe", "probe has-partition recovery", [][]condition{{{false, "probe", []string{"has-partition", "recovery"}}}}, false},
+		{"negated", "!set verbose", [][]condition{{{true, "set", []string{"verbose"}}}}, false},
+		{"negated apart", "! exists /vendor", [][]condition{{{true, "exists", []string{"/vendor"}}}}, false},
+		{"and", "set a && unset b", [][]condition{{
+			{false, "set", []string{"a"}},
+			{false, "unset", []string{"b"}},
+		}}, false},
+		{"or", "set a || !set b", [][]condition{
+			{{false, "set", []string{"a"}}},
+			{{true, "set", []string{"b"}}},
+		}, false},
+		{"and before or", "set a && set b || set c", [][]condition{
+			{{false, "set", []string{"a"}}, {false, "set", []string{"b"}}},
+			{{false, "set", []string{"c"}}},
+		}, false},
+		{"quoted and", `probe sh -c "a && b" && set c`, [][]condition{{
+			{false, "probe", []string{"sh", "-c", `"a && b"`}},
+			{false, "set", []string{"c"}},
+		}}, false},
+		{"quoted or", `$name == 'a || b'`, [][]condition{{{false, "==", []string{"$name", `'a || b'`}}}}, false},
+		{"escaped and", `$name == \&&`, [][]condition{{{false, "==", []string{"$name", `\&&`}}}}, false},
+		{"empty", "", nil, true},
+		{"empty check", "set a && || set b", nil, true},
+		{"trailing and", "set a &&", nil, true},
+		{"only negation", "!", nil, true},
+		{"set without name", "set", nil, true},
+		{"set with two names", "set a b", nil, true},
+		{"exists without path", "exists", nil, true},
+		{"probe without command", "!probe", nil, true},
+		{"unknown check", "verbose", nil, true},
+		{"unknown operator", "$a = b", nil, true},
+		{"unterminated quote", `$a == "b`, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			alternatives, err := parseConditions(test.Expr)
+			if test.Err {
+				if err == nil {
+					t.Fatalf("expected an error, got %d alternatives", len(alternatives))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed := make([][]condition, len(alternatives))
+			for i, conditions := range alternatives {
+				for _, c := range conditions {
+					parsed[i] = append(parsed[i], *c)
+				}
+			}
+			if !reflect.DeepEqual(parsed, test.Alternatives) {
+				t.Errorf("parsed %+v, expected %+v", parsed, test.Alternatives)
+			}
+		})
+	}
+}
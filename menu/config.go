@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/JoshuaDoes/json"
+)
+
+//configLoader merges a menu configuration with the files it includes and the drop-ins next to it, remembering where everything came from
+type configLoader struct {
+	config  *MenuConfig
+	menus   map[string]string //menu ID to the file defining it
+	vars    map[string]string //var name to the file setting it
+	loading map[string]bool   //files being loaded, to catch include cycles
+	loaded  map[string]bool   //files already merged, so a file included twice is merged once
+	appends []configAppend
+}
+
+//configAppend holds items a file appends to a menu, which may be defined by a file loaded after it
+type configAppend struct {
+	File   string
+	MenuID string
+	Items  []*MenuItem
+}
+
+//LoadMenuConfig reads the menu configuration at path along with the files it includes, then every *.json file in dropInDir in name order
+//
+//Files are merged into a single configuration, and a menu ID defined twice or a var set to different values is an error naming both files
+//A missing dropInDir is the same as an empty one
+func LoadMenuConfig(path, dropInDir string) (*MenuConfig, error) {
+	cl := &configLoader{
+		config:  &MenuConfig{Environment: make(map[string]string), Menus: make(map[string]*MenuItemList), Keyboards: make(map[string][]*MenuKeycodeBinding)},
+		menus:   make(map[string]string),
+		vars:    make(map[string]string),
+		loading: make(map[string]bool),
+		loaded:  make(map[string]bool),
+	}
+	if err := cl.load(path, true); err != nil {
+		return nil, err
+	}
+
+	if dropInDir != "" {
+		dropIns, err := filepath.Glob(filepath.Join(dropInDir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, dropIn := range dropIns {
+			if err := cl.load(dropIn, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, pending := range cl.appends {
+		menu := cl.config.Menus[pending.MenuID]
+		if menu == nil {
+			return nil, fmt.Errorf("%s: menu %s to append to is not defined", pending.File, pending.MenuID)
+		}
+		menu.Items = append(menu.Items, pending.Items...)
+	}
+	return cl.config, nil
+}
+
+//load merges the configuration file at path, after the files it includes
+//
+//Included paths are relative to the including file and may be glob patterns, and only the main file may set the home menu
+func (cl *configLoader) load(path string, main bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if cl.loading[abs] {
+		return fmt.Errorf("%s: include cycle", path)
+	}
+	if cl.loaded[abs] {
+		return nil
+	}
+	cl.loading[abs] = true
+	defer delete(cl.loading, abs)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	config := &MenuConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if !main && config.HomeMenu != "" {
+		return fmt.Errorf("%s: only the main menu configuration can set homeMenu", path)
+	}
+
+	for _, include := range config.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		matches, err := filepath.Glob(include)
+		if err != nil {
+			return fmt.Errorf("%s: bad include %s: %v", path, include, err)
+		}
+		if len(matches) == 0 && !hasGlob(include) {
+			return fmt.Errorf("%s: included file %s does not exist", path, include)
+		}
+		for _, match := range matches {
+			if err := cl.load(match, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, value := range config.Environment {
+		if from, ok := cl.vars[name]; ok && cl.config.Environment[name] != value {
+			return fmt.Errorf("%s: var %s is already set to %q by %s", path, name, cl.config.Environment[name], from)
+		}
+		cl.vars[name] = path
+		cl.config.Environment[name] = value
+	}
+	ids := make([]string, 0, len(config.Menus))
+	for id := range config.Menus {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if from, ok := cl.menus[id]; ok {
+			return fmt.Errorf("%s: menu %s is already defined by %s", path, id, from)
+		}
+		if config.Menus[id] == nil {
+			return fmt.Errorf("%s: menu %s is null", path, id)
+		}
+		cl.menus[id] = path
+		cl.config.Menus[id] = config.Menus[id]
+	}
+	ids = ids[:0]
+	for id := range config.Append {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		cl.appends = append(cl.appends, configAppend{File: path, MenuID: id, Items: config.Append[id]})
+	}
+
+	cl.config.Transient = append(cl.config.Transient, config.Transient...)
+	cl.config.Bookmarks = append(cl.config.Bookmarks, config.Bookmarks...)
+	for keyboard, bindings := range config.Keyboards {
+		cl.config.Keyboards[keyboard] = append(cl.config.Keyboards[keyboard], bindings...)
+	}
+	if main {
+		cl.config.HomeMenu = config.HomeMenu
+	}
+	cl.loaded[abs] = true
+	return nil
+}
+
+//hasGlob returns true if path holds a glob pattern, which is allowed to match no files
+func hasGlob(path string) bool {
+	for _, r := range path {
+		switch r {
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadMenuConfig(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Files map[string]string //file paths relative to the config dir, the main one being menu.json and drop-ins in menus.d
+		Items map[string]int    //number of items expected in each menu
+		Err   string            //expected in the error
+	}{
+		{"main only", map[string]string{
+			"menu.json": `{"homeMenu": "home", "menus": {"home": {"items": [{"name": "A"}]}}}`,
+		}, map[string]int{"home": 1}, ""},
+		{"includes and drop-ins", map[string]string{
+			"menu.json":           `{"include": ["inc/*.json", "none/*.json"], "homeMenu": "home", "menus": {"home": {"items": [{"name": "A"}]}}}`,
+			"inc/a.json":          `{"include": ["b.json"], "menus": {"a": {"items": []}}}`,
+			"inc/b.json":          `{"environment": {"x": "1"}, "menus": {"b": {"items": []}}}`,
+			"menus.d/kernel.json": `{"environment": {"x": "1"}, "append": {"home": [{"name": "B"}], "c": [{"name": "C"}]}}`,
+			"menus.d/more.json":   `{"menus": {"c": {"items": []}}}`,
+			"menus.d/skip.txt":    `not json`,
+		}, map[string]int{"home": 2, "a": 0, "b": 0, "c": 1}, ""},
+		{"include cycle", map[string]string{
+			"menu.json": `{"include": ["a.json"], "menus": {}}`,
+			"a.json":    `{"include": ["b.json"]}`,
+			"b.json":    `{"include": ["a.json"]}`,
+		}, nil, "a.json: include cycle"},
+		{"include itself", map[string]string{
+			"menu.json": `{"include": ["menu.json"], "menus": {}}`,
+		}, nil, "menu.json: include cycle"},
+		{"menu ID collision", map[string]string{
+			"menu.json": `{"include": ["a.json"], "menus": {"home": {"items": []}}}`,
+			"a.json":    `{"menus": {"home": {"items": []}}}`,
+		}, nil, "menu.json: menu home is already defined by"},
+		{"menu ID collision in drop-in", map[string]string{
+			"menu.json":      `{"menus": {"home": {"items": []}}}`,
+			"menus.d/a.json": `{"menus": {"home": {"items": []}}}`,
+		}, nil, "a.json: menu home is already defined by"},
+		{"var collision", map[string]string{
+			"menu.json":      `{"environment": {"x": "1"}, "menus": {}}`,
+			"menus.d/a.json": `{"environment": {"x": "2"}}`,
+		}, nil, `a.json: var x is already set to "1" by`},
+		{"null menu", map[string]string{
+			"menu.json": `{"menus": {"home": null}}`,
+		}, nil, "menu.json: menu home is null"},
+		{"home menu outside main", map[string]string{
+			"menu.json":      `{"menus": {}}`,
+			"menus.d/a.json": `{"homeMenu": "a"}`,
+		}, nil, "a.json: only the main menu configuration can set homeMenu"},
+		{"missing include", map[string]string{
+			"menu.json": `{"include": ["gone.json"], "menus": {}}`,
+		}, nil, "gone.json does not exist"},
+		{"append to missing menu", map[string]string{
+			"menu.json":      `{"menus": {}}`,
+			"menus.d/a.json": `{"append": {"gone": [{"name": "A"}]}}`,
+		}, nil, "a.json: menu gone to append to is not defined"},
+		{"bad json", map[string]string{
+			"menu.json": `{"menus": `,
+		}, nil, "menu.json: "},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, data := range test.Files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config, err := LoadMenuConfig(filepath.Join(dir, "menu.json"), filepath.Join(dir, "menus.d"))
+			if test.Err != "" {
+				if err == nil {
+					t.Fatalf("expected an error with %q", test.Err)
+				}
+				if !strings.Contains(err.Error(), test.Err) {
+					t.Errorf("error %q, expected one with %q", err, test.Err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(config.Menus) != len(test.Items) {
+				t.Errorf("%d menus, expected %d", len(config.Menus), len(test.Items))
+			}
+			for id, items := range test.Items {
+				if menu := config.Menus[id]; menu == nil || len(menu.Items) != items {
+					t.Errorf("menu %s is %+v, expected %d items", id, menu, items)
+				}
+			}
+		})
+	}
+}
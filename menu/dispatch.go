@@ -0,0 +1,22 @@
+package main
+
+//Do runs a handler on the menu engine, one at a time, so handlers called from several input goroutines never interleave
+//Every change handler is run after it
+func (me *MenuEngine) Do(handler func()) {
+	me.dispatch.Lock()
+	defer me.dispatch.Unlock()
+	handler()
+	for _, changed := range me.OnChange {
+		changed()
+	}
+}
+
+//Handler returns a handler for an input backend to bind, which runs handler through Do
+//
+//Handlers never wait on commands, which run in the background through Exec, Task or Command, so input is never locked while they run
+//The output pane of an exec item takes the keys to scroll and cancel it instead
+func (me *MenuEngine) Handler(handler func()) func() {
+	return func() {
+		me.Do(handler)
+	}
+}
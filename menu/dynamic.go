@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/JoshuaDoes/json"
+)
+
+//dynamicTimeout is how long the command of a dynamic menu may run before its items are given up on
+const dynamicTimeout = 10 * time.Second
+
+//MenuDynamic holds where the items of a dynamic menu come from, listed again every time the menu is entered
+//
+//The items follow the static items of the menu, if it has any
+type MenuDynamic struct {
+	Exec   string `json:"exec,omitempty"`   //command line printing the items, with vars replaced
+	File   string `json:"file,omitempty"`   //file holding the items, with vars replaced
+	Format string `json:"format"`           //json for a list of items as in the configuration, or lines for an item per line
+	Type   string `json:"type,omitempty"`   //lines: type of every item, note if unset
+	Action string `json:"action,omitempty"` //lines: action of every item, where $? is replaced by the value of the line
+}
+
+//Validate returns an error if the dynamic menu can't be listed whatever its command or file holds
+func (md *MenuDynamic) Validate() error {
+	if (md.Exec == "") == (md.File == "") {
+		return fmt.Errorf("dynamic menu needs either exec or file")
+	}
+	switch md.Format {
+	case "json", "lines":
+	default:
+		return fmt.Errorf("unknown dynamic menu format %s, expected json or lines", md.Format)
+	}
+	return nil
+}
+
+//refresh lists a generated or dynamic menu again, as what it shows may have changed since it was last entered
+func (me *MenuEngine) refresh(menu *MenuItemList) {
+	switch {
+	case menu == nil:
+	case menu.reload != nil:
+		menu.reload(menu)
+	case menu.Dynamic != nil:
+		me.loadDynamic(menu)
+	}
+}
+
+//loadDynamic lists the items of a dynamic menu after its static items, or a note saying why they couldn't be listed
+//
+//Items listed by a command show up once it exits, with a note in their place until then, so input is still handled while it runs
+func (me *MenuEngine) loadDynamic(menu *MenuItemList) {
+	if menu.static == nil {
+		menu.static = append([]*MenuItem{}, menu.Items...)
+	}
+	menu.Items = append([]*MenuItem{}, menu.static...)
+	if menu.listing != nil {
+		close(menu.listing) //Listed again before the last listing was done, which is of no use anymore
+		menu.listing = nil
+	}
+
+	md := menu.Dynamic
+	if err := md.Validate(); err != nil {
+		menu.AddItem("Unable to list items!", "note", err.Error())
+		return
+	}
+	if md.File != "" {
+		data, err := ioutil.ReadFile(me.Vars(md.File))
+		me.listed(menu, md, data, err)
+		return
+	}
+
+	cmdLine, err := me.Args(md.Exec)
+	if err == nil && len(cmdLine) == 0 {
+		err = fmt.Errorf("empty command line")
+	}
+	if err != nil {
+		me.listed(menu, md, nil, err)
+		return
+	}
+	listing := make(chan struct{})
+	menu.listing = listing
+	menu.AddItem("Listing items...", "note", "")
+	var stdout, stderr bytes.Buffer
+	me.Command(cmdLine, &stdout, &stderr, listing, dynamicTimeout, func(code int, err error) {
+		if menu.listing != listing {
+			return //Canceled by listing the menu again
+		}
+		menu.listing = nil
+		switch {
+		case err != nil:
+			err = fmt.Errorf("%s: %v", shellJoin(cmdLine), err)
+		case code != 0:
+			err = fmt.Errorf("%s: exit code %d\n\n%s", shellJoin(cmdLine), code, strings.TrimSpace(stderr.String()))
+		}
+		menu.Items = append([]*MenuItem{}, menu.static...)
+		me.listed(menu, md, stdout.Bytes(), err)
+		if me.Menus[me.LoadedMenu] != menu {
+			return
+		}
+		if me.ItemCursor >= len(menu.Items) {
+			me.ItemCursor = len(menu.Items) - 1
+		}
+		me.render()
+	})
+}
+
+//listed adds the items listed for a dynamic menu after its static items, or a note saying why they couldn't be listed
+func (me *MenuEngine) listed(menu *MenuItemList, md *MenuDynamic, output []byte, err error) {
+	var items []*MenuItem
+	if err == nil {
+		items, err = parseDynamic(md, output)
+	}
+	if err != nil {
+		menu.AddItem("Unable to list items!", "note", err.Error())
+		return
+	}
+	menu.Items = append(menu.Items, items...)
+	if len(items) == 0 {
+		menu.AddItem("Nothing to list", "note", "")
+	}
+}
+
+//parseDynamic returns the items printed by the command or held by the file of a dynamic menu
+func parseDynamic(md *MenuDynamic, output []byte) ([]*MenuItem, error) {
+	if md.Format == "json" {
+		items := make([]*MenuItem, 0)
+		if err := json.Unmarshal(output, &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item == nil {
+				return nil, fmt.Errorf("null item")
+			}
+			for _, expr := range []string{item.VisibleIf, item.EnabledIf} {
+				if _, err := parseConditions(expr); expr != "" && err != nil {
+					return nil, fmt.Errorf("condition of item %s: %v", item.Name, err)
+				}
+			}
+		}
+		return items, nil
+	}
+
+	//Every line is an item, written as a name or as a name and a value separated by a tab
+	itemType := md.Type
+	if itemType == "" {
+		itemType = "note"
+	}
+	items := make([]*MenuItem, 0)
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, value := line, line
+		if fields := strings.SplitN(line, "\t", 2); len(fields) == 2 {
+			name, value = fields[0], fields[1]
+		}
+		name = strings.Replace(name, "$", "$$", -1) //Shown as listed, as the menu replaces vars in its static items
+		items = append(items, &MenuItem{Name: name, Type: itemType, Action: strings.Replace(md.Action, "$?", lineValue(itemType, value), -1)})
+	}
+	return items, nil
+}
+
+//lineValue returns the value of a line ready to replace $? in the action of an item of itemType, so the item gets the value as listed
+func lineValue(itemType, value string) string {
+	switch strings.Split(itemType, " ")[0] {
+	case "exec", "pick", "setvar":
+		return shellQuote(value) //Keep the value a single argument once the action is split, with any $ kept literally by the quotes
+	case "cd", "return":
+		return value //Actions used as is
+	}
+	return strings.Replace(value, "$", "$$", -1) //Keep the value from being expanded as vars
+}
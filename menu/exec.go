@@ -0,0 +1,374 @@
+package main
+
+import (
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	execMenu       = "INTERNAL_EXEC"        //menu ID of the output pane
+	killGrace      = 5 * time.Second        //how long a canceled command has to exit after SIGTERM before it's killed
+	maxJobLines    = 1000                   //lines of output kept in the pane
+	renderInterval = 100 * time.Millisecond //how often output is rendered while a command is writing it
+)
+
+//Job holds an exec item or a task running in the background, whose output is shown in a pane until the user goes back
+type Job struct {
+	CmdLine  []string //the command line of an exec item, or nil for a task
+	Title    string   //shown above the output
+	Message  string   //shown when the command succeeds
+	Running  bool
+	Canceled bool
+	ExitCode int
+	Err      error //set if the command couldn't be run at all
+
+	mu     sync.Mutex //guards lines, which are written from the command's output
+	lines  []string   //output so far, the last line being the one still being written
+	line   []byte     //the bytes of the last line, kept until it ends so characters split across writes stay whole
+	cr     bool       //the last byte written was a carriage return
+	scroll int        //first line shown in the pane
+	rows   int        //lines the pane showed when last rendered
+	follow bool       //keep the last line in view as output arrives
+
+	cancel chan struct{} //closed to cancel the command
+	exited chan struct{} //closed once the runner returns
+	done   chan struct{} //closed once the engine has seen the result
+	then   func()        //runs through Do once the job succeeded, if its pane is still shown, such as to list what a pick item's command printed
+}
+
+//Write adds output to the job, where a carriage return starts the line over like a progress bar would
+func (job *Job) Write(p []byte) (int, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	for _, b := range p {
+		if job.cr && b != '\n' {
+			job.line = job.line[:0]
+		}
+		job.cr = false
+		switch b {
+		case '\n':
+			job.lines[len(job.lines)-1] = string(job.line)
+			job.lines = append(job.lines, "")
+			job.line = job.line[:0]
+		case '\r':
+			job.cr = true
+		default:
+			job.line = append(job.line, b)
+		}
+	}
+	job.lines[len(job.lines)-1] = string(job.line)
+	if len(job.lines) > maxJobLines {
+		job.lines = job.lines[len(job.lines)-maxJobLines:]
+	}
+	return len(p), nil
+}
+
+//Lines returns the output of the job so far
+func (job *Job) Lines() []string {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	lines := append([]string{}, job.lines...)
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1] //Nothing written on the last line yet
+	}
+	return lines
+}
+
+//Cancel asks the command to exit, killing it if it hasn't after killGrace
+func (job *Job) Cancel() {
+	if !job.Running || job.Canceled {
+		return
+	}
+	job.Canceled = true
+	close(job.cancel)
+}
+
+//status returns the state of the job to show under its output
+func (job *Job) status() string {
+	switch {
+	case job.Running && job.Canceled:
+		return "Canceling..."
+	case job.Running:
+		return "Running..."
+	case job.Err != nil:
+		return "Unable to run: " + job.Err.Error()
+	case job.Canceled && job.ExitCode < 0:
+		return "Canceled"
+	case job.Canceled:
+		return "Canceled, exit code " + strconv.Itoa(job.ExitCode)
+	case job.ExitCode < 0:
+		return "Task was killed"
+	case job.ExitCode != 0:
+		return "Task failed with exit code " + strconv.Itoa(job.ExitCode)
+	}
+	return job.Message
+}
+
+//TaskFunc runs a task in the background until it's done or cancel is closed, writing its progress to output and returning an exit code
+//
+//It may replace the Message of its job before returning, such as to show what it computed
+type TaskFunc func(job *Job, output io.Writer, cancel <-chan struct{}) (int, error)
+
+//Exec runs a command line in the background, showing its output in a pane that selecting cancels or leaves once it's finished
+func (me *MenuEngine) Exec(cmdLine []string, message string) {
+	runner := me.runner()
+	job := &Job{CmdLine: cmdLine, Title: "$ " + shellJoin(cmdLine), Message: message}
+	me.startJob(job, func(job *Job, output io.Writer, cancel <-chan struct{}) (int, error) {
+		return runner(cmdLine, output, output, cancel)
+	})
+}
+
+//Command runs a command line in the background without showing the output pane, such as to list the items of a menu, then runs done through Do with its exit code
+//
+//Commands never run within a handler, so input is still handled while they run
+//It's canceled once cancel is closed, if it isn't nil, or once timeout has passed
+func (me *MenuEngine) Command(cmdLine []string, stdout, stderr io.Writer, cancel <-chan struct{}, timeout time.Duration, done func(code int, err error)) {
+	runner := me.runner()
+	me.pending.Add(1)
+	go func() {
+		defer me.pending.Done()
+		stop, release := withTimeout(cancel, timeout)
+		code, err := runner(cmdLine, stdout, stderr, stop)
+		release()
+		me.Do(func() { done(code, err) })
+	}()
+}
+
+//runner returns the runner of command lines, running them as commands unless one was set
+func (me *MenuEngine) runner() func(cmdLine []string, stdout, stderr io.Writer, cancel <-chan struct{}) (int, error) {
+	if me.Runner == nil {
+		return runCommand
+	}
+	return me.Runner
+}
+
+//withTimeout returns a channel closed once cancel is closed or timeout has passed, and a function to release it once it's no longer needed
+func withTimeout(cancel <-chan struct{}, timeout time.Duration) (<-chan struct{}, func()) {
+	stop := make(chan struct{})
+	released := make(chan struct{})
+	go func() {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-cancel:
+		case <-timer.C:
+		case <-released:
+			return
+		}
+		close(stop)
+	}()
+	return stop, func() { close(released) }
+}
+
+//Task runs a function in the background like an exec item, showing what it writes in the output pane
+func (me *MenuEngine) Task(title, message string, task TaskFunc) {
+	me.startJob(&Job{Title: title, Message: message}, task)
+}
+
+//startJob shows the output pane for a job and runs its task
+func (me *MenuEngine) startJob(job *Job, task TaskFunc) {
+	job.Running = true
+	job.lines = []string{""}
+	job.follow = true
+	job.cancel = make(chan struct{})
+	job.exited = make(chan struct{})
+	job.done = make(chan struct{})
+	me.job = job
+	me.AddMenu(execMenu, &MenuItemList{Title: job.Title})
+	me.ChangeMenu(execMenu)
+
+	output := &jobOutput{me: me, job: job}
+	go func() {
+		code, err := task(job, output, job.cancel)
+		close(job.exited)
+		me.Do(func() {
+			job.Running = false
+			job.ExitCode = code
+			job.Err = err
+			defer close(job.done)
+			if me.LoadedMenu != execMenu || me.job != job {
+				return
+			}
+			if job.then != nil && !job.Canceled && job.Err == nil && job.ExitCode == 0 {
+				job.then()
+				return
+			}
+			me.render()
+		})
+	}()
+}
+
+//Wait blocks until the last exec item or task and every command run in the background have finished and the engine has seen their results
+func (me *MenuEngine) Wait() {
+	var job *Job
+	me.Do(func() { job = me.job })
+	if job != nil {
+		<-job.done
+	}
+	me.pending.Wait()
+}
+
+//jobOutput writes the output of a command to its job, rendering it as it arrives
+type jobOutput struct {
+	me  *MenuEngine
+	job *Job
+}
+
+func (out *jobOutput) Write(p []byte) (int, error) {
+	n, err := out.job.Write(p)
+	out.me.renderLater()
+	return n, err
+}
+
+//renderLater renders within renderInterval, so a command writing many lines doesn't redraw the screen for each of them
+func (me *MenuEngine) renderLater() {
+	if !atomic.CompareAndSwapInt32(&me.renderPending, 0, 1) {
+		return
+	}
+	time.AfterFunc(renderInterval, func() {
+		atomic.StoreInt32(&me.renderPending, 0)
+		me.Do(func() {
+			if me.LoadedMenu == execMenu {
+				me.render()
+			}
+		})
+	})
+}
+
+//jobAction cancels the job shown in the pane, or goes back once it's finished
+func (me *MenuEngine) jobAction() {
+	if me.job != nil && me.job.Running {
+		me.job.Cancel()
+		me.render()
+		return
+	}
+	me.PrevMenu()
+}
+
+//scrollJob scrolls the output pane by delta lines, following the output again once scrolled to the end
+func (me *MenuEngine) scrollJob(delta int) {
+	job := me.job
+	if job == nil {
+		return
+	}
+	job.follow = false
+	job.scroll += delta
+	if job.scroll < 0 {
+		job.scroll = 0
+	}
+	if last := len(job.Lines()) - job.rows; job.scroll >= last {
+		job.scroll = last
+		job.follow = true
+	}
+}
+
+//renderJob renders the output pane, fitting as much of the output as the screen allows
+func (me *MenuEngine) renderJob(job *Job) string {
+	title := wrap(job.Title, me.LinesH-4)
+	status := wrap(job.status(), me.LinesH-itemIndent)
+	menu := "- " + strings.Join(title, "\n") + "\n\n\n"
+
+	lines := job.Lines()
+	rows := len(lines)
+	scrolling := false
+	if me.LinesV > 0 {
+		rows = me.LinesV - viewportMargin - len(title) - 2 - len(status) - 2
+		if len(lines) > rows {
+			scrolling = true
+			rows -= 2 //Room for the markers
+		}
+		if rows < 1 {
+			rows = 1
+		}
+	}
+	job.rows = rows
+
+	last := len(lines) - rows
+	if last < 0 {
+		last = 0
+	}
+	if job.follow || job.scroll > last {
+		job.scroll = last
+	}
+	end := job.scroll + rows
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	if scrolling {
+		if job.scroll > 0 {
+			menu += "      ^ " + strconv.Itoa(job.scroll) + " more lines above\n"
+		} else {
+			menu += "\n"
+		}
+	}
+	for _, line := range lines[job.scroll:end] {
+		menu += "      " + truncate(line, me.LinesH-itemIndent) + "\n"
+	}
+	if scrolling {
+		if below := len(lines) - end; below > 0 {
+			menu += "      v " + strconv.Itoa(below) + " more lines below\n"
+		} else {
+			menu += "\n"
+		}
+	}
+
+	menu += "\n"
+	for _, line := range status {
+		menu += "      " + line + "\n"
+	}
+	if job.Running {
+		menu += "   --> Cancel\n"
+	} else {
+		menu += "   --> Go back\n"
+	}
+	return menu
+}
+
+//runCommand runs a command line in its own process group, so canceling it also stops the programs a script runs
+//
+//Commands get no stdin on purpose, so reading input gets EOF instead of stealing keys from the tty input backend
+//Reading the terminal from their own process group would also stop them with SIGTTIN
+func runCommand(cmdLine []string, stdout, stderr io.Writer, cancel <-chan struct{}) (int, error) {
+	cmd := exec.Command(cmdLine[0], cmdLine[1:]...)
+	cmd.Stdin = nil //Read from the null device
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return -1, err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-exited:
+			return
+		case <-cancel:
+		}
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		select {
+		case <-exited:
+		case <-time.After(killGrace):
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+	}()
+
+	err := cmd.Wait()
+	close(exited)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJobWrite(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Writes []string
+		Lines  []string
+	}{
+		{"lines", []string{"one\ntwo\n"}, []string{"one", "two"}},
+		{"unfinished line", []string{"one\ntw", "o"}, []string{"one", "two"}},
+		{"multibyte", []string{"héllo • ok\n"}, []string{"héllo • ok"}},
+		{"multibyte split across writes", []string{"  \xe2\x80", "\xa2 Flashing boot_a\n"}, []string{"  • Flashing boot_a"}},
+		{"carriage return", []string{"10%\r20%\r", "30%\n"}, []string{"30%"}},
+		{"carriage return before newline", []string{"done\r\n"}, []string{"done"}},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			job := &Job{lines: []string{""}}
+			for _, write := range test.Writes {
+				if n, err := job.Write([]byte(write)); n != len(write) || err != nil {
+					t.Fatalf("wrote %d of %d bytes: %v", n, len(write), err)
+				}
+			}
+			if lines := job.Lines(); !reflect.DeepEqual(lines, test.Lines) {
+				t.Errorf("lines %q, expected %q", lines, test.Lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	explorerMenu     = "INTERNAL_EXPLORER"       //menu ID of the explorer
+	explorerGoToMenu = "INTERNAL_EXPLORER_GO_TO" //menu ID of the bookmarks and paths the explorer can go to
+)
+
+//ExplorerOptions holds how the explorer lists a directory, set by key=value options after its path in the item type
+//
+//For example: explorer /sdcard/ sort=mtime filter=img,zip hidden=false
+type ExplorerOptions struct {
+	Sort    string   //sort=name (A to Z), size (largest first) or mtime (newest first)
+	Dirs    string   //dirs=first to list directories before files, or mixed to sort them together
+	Hidden  bool     //hidden=true to list files starting with a dot
+	Filter  []string //filter=ext1,ext2 to only list files with those extensions
+	Actions bool     //actions=true to open a menu of file actions when a file is selected, see File
+}
+
+//NewExplorerOptions returns the default explorer options
+func NewExplorerOptions() *ExplorerOptions {
+	return &ExplorerOptions{Sort: "name", Dirs: "first", Hidden: true}
+}
+
+//parseExplorer splits the arguments of an explorer item type into its directory and options, where options come last
+func parseExplorer(args []string) (string, *ExplorerOptions, error) {
+	opts := NewExplorerOptions()
+	end := len(args)
+	for ; end > 0; end-- {
+		kv := strings.SplitN(args[end-1], "=", 2)
+		if len(kv) != 2 {
+			break
+		}
+		switch kv[0] {
+		case "sort":
+			switch kv[1] {
+			case "name", "size", "mtime":
+			default:
+				return "", nil, fmt.Errorf("unknown explorer sort %s, expected name, size or mtime", kv[1])
+			}
+			opts.Sort = kv[1]
+		case "dirs":
+			switch kv[1] {
+			case "first", "mixed":
+			default:
+				return "", nil, fmt.Errorf("unknown explorer dirs %s, expected first or mixed", kv[1])
+			}
+			opts.Dirs = kv[1]
+		case "hidden":
+			opts.Hidden = kv[1] == "true"
+		case "actions":
+			opts.Actions = kv[1] == "true"
+		case "filter":
+			opts.Filter = nil
+			if kv[1] != "" {
+				opts.Filter = strings.Split(kv[1], ",")
+			}
+		default:
+			return "", nil, fmt.Errorf("unknown explorer option %s", args[end-1])
+		}
+	}
+
+	dir := strings.Join(args[:end], " ")
+	if dir == "" {
+		dir = "/"
+	}
+	return dir, opts, nil
+}
+
+//filtered returns true if the file name is allowed by the extension filter
+func (opts *ExplorerOptions) filtered(name string) bool {
+	if len(opts.Filter) == 0 {
+		return true
+	}
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	for _, filter := range opts.Filter {
+		if strings.TrimPrefix(strings.ToLower(filter), ".") == ext {
+			return true
+		}
+	}
+	return false
+}
+
+//sort sorts the files of a directory by the sort and dirs options
+func (opts *ExplorerOptions) sort(files []os.FileInfo) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if opts.Dirs == "first" && a.IsDir() != b.IsDir() {
+			return a.IsDir()
+		}
+		switch {
+		case opts.Sort == "size" && a.Size() != b.Size():
+			return a.Size() > b.Size()
+		case opts.Sort == "mtime" && !a.ModTime().Equal(b.ModTime()):
+			return a.ModTime().After(b.ModTime())
+		}
+		return strings.ToLower(a.Name()) < strings.ToLower(b.Name())
+	})
+}
+
+//dirPath returns a directory path ending with a slash, so file names can be appended to it
+func dirPath(dir string) string {
+	dir = filepath.Clean(dir)
+	if dir == "/" {
+		return dir
+	}
+	return dir + "/"
+}
+
+//humanSize returns a size in bytes as a short human readable string
+func humanSize(size int64) string {
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+//Bookmark holds a directory the explorer can go to directly
+type Bookmark struct {
+	Name string `json:"name"`
+	Path string `json:"path"` //vars are replaced
+}
+
+//ExplorerState holds where the explorer is, as every directory is listed in the same menu instead of piling onto the menu history
+type ExplorerState struct {
+	Dir  string //the directory being listed
+	Bin  string //the command line run on a selected file, with $? replaced by its path
+	Opts *ExplorerOptions
+
+	stack []explorerDir //the directories to go back to, the last one entered on top
+}
+
+//explorerDir holds a directory the explorer went back from, with where its item cursor was
+type explorerDir struct {
+	Dir    string
+	Cursor int
+}
+
+//Explorer abuses the powers of AddMenu, ChangeMenu, and PrevMenu to create a file browser with support for passing a selected file to an executable
+//
+//It's a single menu with menuID "INTERNAL_EXPLORER" that lists whichever directory it's in, so leaving it is a single step back
+func (me *MenuEngine) Explorer(workingDir, bin string, opts *ExplorerOptions) {
+	me.explorer = &ExplorerState{Dir: dirPath(workingDir), Bin: bin, Opts: opts}
+	explorer := &MenuItemList{}
+	explorer.reload = func(explorer *MenuItemList) {
+		me.listDir(explorer, me.explorer)
+	}
+	explorer.reload(explorer)
+
+	goTo := &MenuItemList{Title: "Go to", Items: make([]*MenuItem, 0)}
+	for _, bookmark := range me.Bookmarks {
+		path := dirPath(me.Vars(bookmark.Path))
+		goTo.AddItem(me.Vars(bookmark.Name)+" ("+path+")", "cd", path)
+	}
+	if len(me.Bookmarks) > 0 {
+		goTo.AddItem("", "divider", "1")
+	}
+	goTo.AddItem("Type a path ...", "jump", "")
+
+	me.AddMenu(explorerGoToMenu, goTo)
+	me.AddMenu(explorerMenu, explorer)
+	me.scroll = 0
+	me.ChangeMenu(explorerMenu)
+}
+
+//Cd lists another directory in the explorer, which going back returns from
+func (me *MenuEngine) Cd(dir string) {
+	if me.explorer == nil {
+		me.ErrorText("No explorer is open")
+		return
+	}
+
+	//Back out of the menus opened from the explorer, such as the bookmarks
+	for me.LoadedMenu != explorerMenu {
+		loaded := me.LoadedMenu
+		me.PrevMenu()
+		if me.LoadedMenu == loaded {
+			return //Stuck, such as on a running exec item
+		}
+	}
+
+	me.explorer.stack = append(me.explorer.stack, explorerDir{Dir: me.explorer.Dir, Cursor: me.ItemCursor})
+	me.explorer.Dir = dirPath(dir)
+	me.listDir(me.Menus[explorerMenu], me.explorer)
+	me.ItemCursor = -1
+	me.scroll = 0 //Every directory shares the explorer menu, so the viewport wouldn't start over on its own
+	me.render()
+}
+
+//explorerBack returns to the last directory the explorer was in, returning false if it's in the one it was opened in
+func (me *MenuEngine) explorerBack() bool {
+	if me.explorer == nil || len(me.explorer.stack) == 0 {
+		return false
+	}
+	last := me.explorer.stack[len(me.explorer.stack)-1]
+	me.explorer.stack = me.explorer.stack[:len(me.explorer.stack)-1]
+	me.explorer.Dir = last.Dir
+	me.listDir(me.Menus[explorerMenu], me.explorer)
+	me.ItemCursor = last.Cursor
+	if me.ItemCursor >= len(me.Menus[explorerMenu].Items) {
+		me.ItemCursor = -1
+	}
+	me.scroll = 0 //Scrolled back down to the cursor by the viewport
+	me.render()
+	return true
+}
+
+//closeExplorer leaves the explorer from whichever directory it's in, back to the menu that opened it
+func (me *MenuEngine) closeExplorer() {
+	if me.LoadedMenu != explorerMenu {
+		return
+	}
+	me.explorer.stack = nil
+	me.scroll = 0
+	me.PrevMenu()
+}
+
+//Jump opens the on-screen keyboard to type a directory for the explorer to go to
+func (me *MenuEngine) Jump() {
+	if me.explorer == nil {
+		me.ErrorText("No explorer is open")
+		return
+	}
+	me.openInput(&MenuInput{
+		Var:   "path",
+		Value: me.explorer.Dir,
+		Save: func(path string) error {
+			path = me.Vars(path)
+			if stat, err := os.Stat(path); err != nil || !stat.IsDir() {
+				return fmt.Errorf("Path %s is not a directory!", path)
+			}
+			me.Cd(path)
+			return nil
+		},
+	})
+}
+
+//listDir lists the directory the explorer is in as the items of its menu
+func (me *MenuEngine) listDir(explorer *MenuItemList, state *ExplorerState) {
+	workingDir, bin, opts := state.Dir, state.Bin, state.Opts
+	displayBin := workingDir
+	if bin != "" {
+		displayBin = strings.Replace(me.Vars(bin), "$?", workingDir, -1)
+	}
+	explorer.Title = "Explorer - " + displayBin
+	explorer.Items = make([]*MenuItem, 0)
+	if workingDir != "/" {
+		explorer.AddItem("../", "cd", filepath.Join(workingDir, ".."))
+	}
+	explorer.AddItem("Go to ...", "menu", explorerGoToMenu)
+	explorer.AddItem("", "divider", "1")
+
+	dirStat, err := os.Stat(workingDir)
+	switch {
+	case os.IsNotExist(err):
+		explorer.AddItem("Path "+workingDir+" does not exist!", "note", "")
+	case os.IsPermission(err):
+		explorer.AddItem("Path "+workingDir+" is not accessible!", "note", "")
+	case err != nil:
+		explorer.AddItem("Path "+workingDir+" has unknown errors!", "note", fmt.Sprintf("%v", err))
+	case !dirStat.IsDir():
+		explorer.AddItem("Path "+workingDir+" is not a directory!", "note", "")
+	default:
+		entries, err := ioutil.ReadDir(workingDir)
+		if err != nil {
+			explorer.AddItem("Path "+workingDir+" has unreadable file contents!", "note", fmt.Sprintf("%v", err))
+			break
+		}
+
+		//Follow symlinks, so links to directories can be browsed
+		files := make([]os.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			if !opts.Hidden && strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			fileStat, err := os.Stat(workingDir + entry.Name())
+			if err != nil || (!fileStat.IsDir() && !opts.filtered(entry.Name())) {
+				continue
+			}
+			files = append(files, fileStat)
+		}
+		opts.sort(files)
+
+		for _, file := range files {
+			path := workingDir + file.Name()
+			switch {
+			case file.IsDir():
+				explorer.AddItem(file.Name()+"/", "cd", path+"/")
+			case opts.Actions:
+				explorer.Items = append(explorer.Items, fileItem(fileDetails(file), "", "open", path, bin))
+			case bin != "":
+				explorer.AddItem(fileDetails(file), "exec", strings.Replace(bin, "$?", shellQuote(path), -1))
+			default:
+				explorer.AddItem(fileDetails(file), "return", path)
+			}
+		}
+		if len(files) == 0 {
+			explorer.AddItem("No files here", "note", "")
+		}
+	}
+}
+
+//fileDetails returns the name of a file followed by its size and modification time
+func fileDetails(file os.FileInfo) string {
+	return fmt.Sprintf("%s  (%s, %s)", file.Name(), humanSize(file.Size()), file.ModTime().Format("2006-01-02 15:04"))
+}
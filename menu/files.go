@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/JoshuaDoes/jdtoolbox/filetype"
+)
+
+const (
+	fileMenu     = "INTERNAL_FILE"      //menu ID of the actions for a file selected in the explorer
+	fileInfoMenu = "INTERNAL_FILE_INFO" //menu ID of the details of a file
+	fileDirMenu  = "INTERNAL_FILE_DIR"  //menu ID of the directories a file can be copied or moved to
+	fileChunk    = 1024 * 1024          //bytes read at a time when hashing or copying a file, between progress updates
+)
+
+//errCanceled is returned when a file task stops because it was canceled
+var errCanceled = errors.New("canceled")
+
+//fileItem returns an item that runs a file action when selected, see File
+func fileItem(name, confirm string, args ...string) *MenuItem {
+	return &MenuItem{Name: name, Type: "file", Argv: args, Confirm: confirm}
+}
+
+//File runs an action on a file selected in the explorer, given as the action followed by the path of the file and its arguments
+//
+//Actions are open PATH BIN, info PATH, sha256 PATH, md5 PATH, rename PATH BIN, delete PATH, copy PATH and move PATH
+//Copying and moving pick a directory with browse PATH copy|move DIR, then copy or move the file with copyto|moveto PATH DIR
+func (me *MenuEngine) File(args []string) {
+	if len(args) < 2 {
+		me.ErrorText("Missing file for file action: " + strings.Join(args, " "))
+		return
+	}
+	action, path := args[0], args[1]
+	arg := func(i int) string {
+		if len(args) > i+2 {
+			return args[i+2]
+		}
+		return ""
+	}
+
+	switch action {
+	case "open":
+		me.AddMenu(fileMenu, me.fileMenu(path, arg(0)))
+		me.ChangeMenu(fileMenu)
+	case "info":
+		me.fileInfo(path)
+	case "sha256":
+		me.hashFile(path, "SHA-256", sha256.New())
+	case "md5":
+		me.hashFile(path, "MD5", md5.New())
+	case "rename":
+		me.renameFile(path, arg(0))
+	case "delete":
+		if err := os.Remove(path); err != nil {
+			me.ErrorText("Unable to delete " + path + ": " + err.Error())
+			return
+		}
+		me.PrevMenu() //Back to the explorer, which lists the directory again
+	case "copy", "move":
+		me.pickDir(action, path, filepath.Dir(path))
+	case "browse":
+		me.pickDir(arg(0), path, arg(1))
+	case "copyto", "moveto":
+		me.PrevMenu() //Back to the file
+		me.copyFile(path, arg(0), action == "moveto")
+	default:
+		me.ErrorText("Unknown file action: " + action)
+	}
+}
+
+//fileMenu returns the menu of actions for a file, where bin is run on it like the explorer would if set
+func (me *MenuEngine) fileMenu(path, bin string) *MenuItemList {
+	menu := &MenuItemList{}
+	menu.reload = func(menu *MenuItemList) {
+		menu.Title = "File - " + path
+		menu.Items = make([]*MenuItem, 0)
+		if _, err := os.Stat(path); err != nil {
+			menu.AddItem("File no longer exists!", "note", "")
+			return
+		}
+
+		switch {
+		case bin != "":
+			cmdLine := strings.Replace(bin, "$?", shellQuote(path), -1)
+			menu.AddItem("Run "+strings.Replace(me.Vars(bin), "$?", shellQuote(path), -1), "exec", cmdLine)
+			menu.AddItem("", "divider", "1")
+		case me.Return != "":
+			menu.AddItem("Pick this file", "return", path)
+			menu.AddItem("", "divider", "1")
+		}
+		menu.Items = append(menu.Items,
+			fileItem("Details ...", "", "info", path),
+			fileItem("SHA-256 ...", "", "sha256", path),
+			fileItem("MD5 ...", "", "md5", path),
+			fileItem("Copy to ...", "", "copy", path),
+			fileItem("Move to ...", "", "move", path),
+			fileItem("Rename ...", "", "rename", path, bin),
+			fileItem("Delete", "Delete "+filepath.Base(path)+"?", "delete", path),
+		)
+	}
+	menu.reload(menu)
+	return menu
+}
+
+//fileInfo lists the details of a file
+func (me *MenuEngine) fileInfo(path string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		me.ErrorText("Unable to read " + path + ": " + err.Error())
+		return
+	}
+
+	details := &MenuItemList{Title: "Details - " + path}
+	details.AddItem("Size: "+humanSize(info.Size())+" ("+strconv.FormatInt(info.Size(), 10)+" bytes)", "note", "")
+	details.AddItem("Modified: "+info.ModTime().Format("2006-01-02 15:04:05"), "note", "")
+	details.AddItem("Mode: "+info.Mode().String(), "note", "")
+	details.AddItem("Owner: "+fileOwner(info), "note", "")
+	details.AddItem("SELinux: "+selinuxContext(path), "note", "")
+	details.AddItem("Type: "+fileType(path), "note", "")
+	me.AddMenu(fileInfoMenu, details)
+	me.ChangeMenu(fileInfoMenu)
+}
+
+//fileOwner returns the user and group owning a file, with their names if they can be looked up
+func fileOwner(info os.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "unknown"
+	}
+	uid, gid := strconv.Itoa(int(stat.Uid)), strconv.Itoa(int(stat.Gid))
+	owner, group := uid, gid
+	if u, err := user.LookupId(uid); err == nil {
+		owner = u.Username + " (" + uid + ")"
+	}
+	if g, err := user.LookupGroupId(gid); err == nil {
+		group = g.Name + " (" + gid + ")"
+	}
+	return owner + ", group " + group
+}
+
+//selinuxContext returns the SELinux context of a file, or none if it has none
+func selinuxContext(path string) string {
+	context := make([]byte, 256)
+	n, err := syscall.Getxattr(path, "security.selinux", context)
+	if err != nil || n <= 0 {
+		return "none"
+	}
+	return strings.TrimRight(string(context[:n]), "\x00")
+}
+
+//fileType detects what a file holds from its first bytes, telling text apart from other data the installers don't handle
+func fileType(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return "unknown"
+	}
+	defer f.Close()
+
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(f, header)
+	header = header[:n]
+	switch detected := filetype.Detect(header); {
+	case n == 0:
+		return "empty"
+	case detected != filetype.Unknown:
+		return detected.String()
+	case utf8.Valid(header) && bytes.IndexByte(header, 0) < 0:
+		return "text"
+	}
+	return filetype.Unknown.String()
+}
+
+//hashFile computes the hash of a file in the output pane, showing it once it's done
+func (me *MenuEngine) hashFile(path, name string, h hash.Hash) {
+	me.Task(name+" of "+path, "", func(job *Job, output io.Writer, cancel <-chan struct{}) (int, error) {
+		in, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(output, err)
+			return 1, nil
+		}
+		defer in.Close()
+		info, err := in.Stat()
+		if err != nil {
+			fmt.Fprintln(output, err)
+			return 1, nil
+		}
+
+		if err := copyProgress(h, in, info.Size(), name, output, cancel); err != nil {
+			if err == errCanceled {
+				return -1, nil
+			}
+			fmt.Fprintln(output, err)
+			return 1, nil
+		}
+		job.Message = name + ": " + hex.EncodeToString(h.Sum(nil))
+		return 0, nil
+	})
+}
+
+//copyProgress copies size bytes from in to out a chunk at a time, writing the progress to output and stopping if cancel is closed
+func copyProgress(out io.Writer, in io.Reader, size int64, label string, output io.Writer, cancel <-chan struct{}) error {
+	done := int64(0)
+	percent := -1
+	for {
+		select {
+		case <-cancel:
+			return errCanceled
+		default:
+		}
+
+		n, err := io.CopyN(out, in, fileChunk)
+		done += n
+		if size > 0 {
+			p := int(done * 100 / size)
+			if p > 100 {
+				p = 100 //The file grew while it was read
+			}
+			if p != percent {
+				percent = p
+				fmt.Fprintf(output, "\r%s %d%% (%s of %s)", label, p, humanSize(done), humanSize(size))
+			}
+		}
+		if err == io.EOF {
+			fmt.Fprintln(output)
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintln(output)
+			return err
+		}
+	}
+}
+
+//renameFile opens the on-screen keyboard to rename a file, returning to its menu under the new name
+func (me *MenuEngine) renameFile(path, bin string) {
+	me.openInput(&MenuInput{
+		Var:   "new name",
+		Value: filepath.Base(path),
+		Save: func(name string) error {
+			if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+				return fmt.Errorf("Invalid file name: %s", name)
+			}
+			newPath := filepath.Join(filepath.Dir(path), name)
+			if newPath == path {
+				return nil
+			}
+			if _, err := os.Lstat(newPath); err == nil {
+				return fmt.Errorf("File %s already exists!", newPath)
+			}
+			if err := os.Rename(path, newPath); err != nil {
+				return fmt.Errorf("Unable to rename %s: %v", path, err)
+			}
+			me.AddMenu(fileMenu, me.fileMenu(newPath, bin))
+			me.PrevMenu() //Back to the file under its new name
+			return nil
+		},
+	})
+}
+
+//pickDir lists the directories of dir to copy or move a file to, listing each in the same menu so leaving it is a single step back
+func (me *MenuEngine) pickDir(action, path, dir string) {
+	dir = dirPath(dir)
+	verb := "Copy"
+	if action == "move" {
+		verb = "Move"
+	}
+
+	dirs := &MenuItemList{
+		Title: verb + " " + filepath.Base(path) + " to " + dir,
+		Items: make([]*MenuItem, 0),
+	}
+	dirs.Items = append(dirs.Items, fileItem(verb+" here", "", action+"to", path, dir))
+	dirs.AddItem("", "divider", "1")
+	if dir != "/" {
+		dirs.Items = append(dirs.Items, fileItem("../", "", "browse", path, action, filepath.Join(dir, "..")))
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		dirs.AddItem("Path "+dir+" has unreadable file contents!", "note", fmt.Sprintf("%v", err))
+	}
+	for _, entry := range entries {
+		if fileStat, err := os.Stat(dir + entry.Name()); err == nil && fileStat.IsDir() {
+			dirs.Items = append(dirs.Items, fileItem(entry.Name()+"/", "", "browse", path, action, dir+entry.Name()))
+		}
+	}
+
+	me.AddMenu(fileDirMenu, dirs)
+	if me.LoadedMenu != fileDirMenu {
+		me.ChangeMenu(fileDirMenu)
+		return
+	}
+	me.ItemCursor = -1
+	me.render()
+}
+
+//copyFile copies or moves a file into dir in the output pane, never replacing a file that's already there
+func (me *MenuEngine) copyFile(path, dir string, move bool) {
+	newPath := dirPath(dir) + filepath.Base(path)
+	label, message := "Copying", "Copied to "+newPath
+	if move {
+		label, message = "Moving", "Moved to "+newPath
+	}
+
+	me.Task(label+" "+path+" to "+dirPath(dir), message, func(job *Job, output io.Writer, cancel <-chan struct{}) (int, error) {
+		if _, err := os.Lstat(newPath); err == nil {
+			fmt.Fprintln(output, "File "+newPath+" already exists!")
+			return 1, nil
+		}
+		if move {
+			err := os.Rename(path, newPath)
+			if err == nil {
+				return 0, nil
+			}
+			if linkErr, ok := err.(*os.LinkError); !ok || linkErr.Err != syscall.EXDEV {
+				fmt.Fprintln(output, err)
+				return 1, nil
+			}
+			//Renaming doesn't work across filesystems, so copy the file and delete it instead
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(output, err)
+			return 1, nil
+		}
+		defer in.Close()
+		info, err := in.Stat()
+		if err != nil {
+			fmt.Fprintln(output, err)
+			return 1, nil
+		}
+		out, err := os.OpenFile(newPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+		if err != nil {
+			fmt.Fprintln(output, err)
+			return 1, nil
+		}
+
+		err = copyProgress(out, in, info.Size(), label, output, cancel)
+		if err == nil {
+			err = out.Sync()
+		}
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(newPath) //Don't leave half a file behind
+			if err == errCanceled {
+				return -1, nil
+			}
+			fmt.Fprintln(output, err)
+			return 1, nil
+		}
+
+		if move {
+			if err := os.Remove(path); err != nil {
+				fmt.Fprintln(output, "Copied, but unable to delete "+path+": "+err.Error())
+				return 1, nil
+			}
+		}
+		return 0, nil
+	})
+}
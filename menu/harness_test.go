@@ -0,0 +1,276 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "write the frames of the headless menu tests to their golden files instead of comparing them")
+
+//Headless drives a menu engine from a script of actions instead of input devices, capturing the frame rendered after each action
+//
+//Commands are never run, the command lines they would have run are recorded with the frames instead, printing the output of their fake if they have one
+type Headless struct {
+	Engine *MenuEngine
+	Frames []string
+	Fakes  map[string]*Fake //output and exit code of the command lines that aren't just recorded, keyed by the command line as shown
+
+	mutex sync.Mutex //guards execs, as commands run in the background
+	execs []string   //command lines run since the last frame
+}
+
+//Fake holds what a faked command line prints and exits with
+type Fake struct {
+	Output string
+	File   string //file in testdata printed after Output, such as one too long to hold in a string
+	Code   int
+}
+
+//NewHeadless returns a headless driver for a menu engine, replacing its renderer and exec runner
+func NewHeadless(me *MenuEngine) *Headless {
+	h := &Headless{Engine: me, Frames: make([]string, 0), Fakes: make(map[string]*Fake)}
+	me.Render = func(string) {} //Frames are rendered once the engine is idle, rendering still starts probes like it would on a screen
+	me.Runner = h.exec
+	return h
+}
+
+func (h *Headless) exec(cmdLine []string, stdout, stderr io.Writer, cancel <-chan struct{}) (int, error) {
+	h.mutex.Lock()
+	h.execs = append(h.execs, shellJoin(cmdLine))
+	h.mutex.Unlock()
+	if fake, ok := h.Fakes[shellJoin(cmdLine)]; ok {
+		io.WriteString(stdout, fake.Output)
+		if fake.File != "" {
+			data, err := ioutil.ReadFile(fake.File)
+			if err != nil {
+				return 0, err
+			}
+			stdout.Write(data)
+		}
+		return fake.Code, nil
+	}
+	return 0, nil
+}
+
+//Run starts at the home menu and runs each action of a script, such as next,next,select,back
+//Actions are next, prev, select, back and home
+func (h *Headless) Run(script string) error {
+	h.Engine.Do(h.Engine.Home)
+	h.Engine.Wait()
+	h.frame("home")
+	for _, action := range strings.Split(script, ",") {
+		action = strings.TrimSpace(action)
+		var handler func()
+		switch action {
+		case "next":
+			handler = h.Engine.NextItem
+		case "prev":
+			handler = h.Engine.PrevItem
+		case "select":
+			handler = h.Engine.Action
+		case "back":
+			handler = h.Engine.PrevMenu
+		case "home":
+			handler = h.Engine.Home
+		case "":
+			continue
+		default:
+			return fmt.Errorf("unknown script action: %s", action)
+		}
+		h.Engine.Handler(handler)()
+		h.Engine.Wait()
+		h.frame(action)
+	}
+	return nil
+}
+
+//frame captures what the engine would render now
+func (h *Headless) frame(action string) {
+	frame := fmt.Sprintf("### %d: %s\n", len(h.Frames), action)
+	h.mutex.Lock()
+	sort.Strings(h.execs) //Commands run in the background at once, such as probes, finish in any order
+	for _, cmdLine := range h.execs {
+		frame += "### exec: " + cmdLine + "\n"
+	}
+	h.execs = nil
+	h.mutex.Unlock()
+	frame += h.Engine.GetRender() + "\n"
+	h.Frames = append(h.Frames, frame)
+}
+
+func (h *Headless) String() string {
+	return strings.Join(h.Frames, "")
+}
+
+//Compare compares the captured frames to a golden file, or writes them to it if update is set
+func (h *Headless) Compare(golden string, update bool) error {
+	if update {
+		return ioutil.WriteFile(golden, []byte(h.String()), 0644)
+	}
+
+	data, err := ioutil.ReadFile(golden)
+	if err != nil {
+		return err
+	}
+	want := strings.SplitAfter(string(data), "\n### ")
+	got := strings.SplitAfter(h.String(), "\n### ")
+	for i := 0; i < len(want) || i < len(got); i++ {
+		switch {
+		case i >= len(got):
+			return fmt.Errorf("%s: missing frame:\n%s", golden, want[i])
+		case i >= len(want):
+			return fmt.Errorf("%s: unexpected frame:\n%s", golden, got[i])
+		case got[i] != want[i]:
+			return fmt.Errorf("%s: frame differs, expected:\n%s\ngot:\n%s", golden, want[i], got[i])
+		}
+	}
+	return nil
+}
+
+//testdata is the absolute path to the checked-in testdata, where the golden files are compared to and written
+var testdata string
+
+//TestMain runs the tests from a copy of testdata, where the menus find their files, with file dates pinned down as the explorer shows them
+//
+//The copy keeps the tests from changing the source tree, such as the dates of its files or the files the file actions write
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	var err error
+	testdata, err = filepath.Abs("testdata")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	dir, err := ioutil.TempDir("", "menu-testdata")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	if err := copyTree(testdata, dir); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := os.Chdir(dir); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	time.Local = time.UTC
+	for path, date := range map[string]string{
+		"tree/boot.img":     "2024-01-01 10:00",
+		"tree/notes.txt":    "2024-01-02 10:00",
+		"tree/My Notes.txt": "2024-01-03 10:00",
+		"tree/sub/dtb.img":  "2024-01-04 10:00",
+	} {
+		mtime, err := time.Parse("2006-01-02 15:04", date)
+		if err == nil {
+			err = os.Chtimes(path, mtime, mtime)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	logs := time.Date(2024, 1, 5, 10, 0, 0, 0, time.UTC)
+	err = filepath.Walk("var/log", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		return os.Chtimes(path, logs, logs)
+	})
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return m.Run()
+}
+
+//copyTree copies the files and directories under src into dst
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, strings.TrimPrefix(path, src))
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, info.Mode().Perm())
+	})
+}
+
+//goldenTests holds the scripts run against testdata/menu.json and the golden files their frames are compared to
+var goldenTests = []struct {
+	Golden string
+	Script string
+}{
+	//Cursor wrap and divider skipping in both directions
+	{"navigate.golden", "prev,prev,next,next,next,next"},
+	//Menu history, skipping disabled and hidden items, typed vars, picking a file through the explorer, declining then confirming a fake exec
+	{"install.golden", "select,prev,next,next,select,next,next,next,next,select,next,next,next,select,next,next,next,select,next,select,next,select,next,select,select,select,next,select,back,back"},
+	//Explorer directories and their parents going back within the explorer, a file name with a space, a bookmark and leaving the explorer in one step
+	{"explorer.golden", "next,select,next,next,next,select,next,select,back,back,next,select,back,prev,prev,select,next,select,back,back,back"},
+	//File actions: hashes, browsing the directories to copy to and back up, refusing to copy over a file and declining to delete
+	{"files.golden", "next,next,select,next,next,next,next,select,next,next,select,back,next,select,back,next,select,next,next,next,select,next,next,select,next,select,back,next,next,next,select,select,back,back"},
+	//Scrolling to the end of a long directory, then entering and leaving another long one, which start at the top and at the cursor
+	{"scroll.golden", "select,prev,prev,select,prev,select,prev,back,back,back"},
+	//Dynamic menus listed from a file of lines, from the JSON printed by a command, and from a missing file
+	{"dynamic.golden", "next,next,next,select,next,select,next,next,select,back,back,next,select,next,select,back,back,next,next,next,select,back,back"},
+	//A menu pack from menus.d appended to a menu of the main file, sharing its slot var
+	{"packs.golden", "select,prev,select,next,next,select,back,back"},
+	//Confirming an item of a dynamic menu, which is listed again behind the question before its action runs
+	{"confirm.golden", "next,next,next,select,next,select,next,next,select,next,select,select,back,back"},
+	//Picking from the choices a command prints, shown in the output pane while it runs
+	{"pick.golden", "select,prev,select,next,next,next,select,next,next,select,back,back"},
+}
+
+//fakes holds the output of the commands the menus in testdata list their choices and items from, and the probes that fail
+var fakes = map[string]*Fake{
+	"has-partition recoveryother": {Code: 1},
+	"list-modules --format json": {File: "modules.json"},
+	"list-dtbs": {Output: `[{"name": "Board one", "value": "one.dtb"}, {"name": "Board two", "value": "two.dtb"}]`},
+}
+
+//TestGolden drives the menus headless through each script, comparing every frame to its golden file
+//
+//Run go test -update to rewrite the golden files after an intended change
+func TestGolden(t *testing.T) {
+	for _, test := range goldenTests {
+		t.Run(strings.TrimSuffix(test.Golden, ".golden"), func(t *testing.T) {
+			config, err := LoadMenuConfig("menu.json", "menus.d")
+			if err != nil {
+				t.Fatal(err)
+			}
+			me, err := loadEngine(config, nil, 60, 24, ".")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := NewHeadless(me)
+			h.Fakes = fakes
+			if err := h.Run(test.Script); err != nil {
+				t.Fatal(err)
+			}
+			if err := h.Compare(filepath.Join(testdata, test.Golden), *update); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
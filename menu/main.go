@@ -7,8 +7,9 @@ import (
 //	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"sort"
 //	"strconv"
-//	"strings"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,10 +18,14 @@ import (
 )
 
 type MenuConfig struct {
+	Include []string `json:"include"` //other configuration files to merge in, relative to this one and may be glob patterns
 	Environment map[string]string  `json:"environment"`
+	Transient []string `json:"transient"` //vars that aren't saved to the state file
+	Bookmarks []*Bookmark `json:"bookmarks"` //directories the explorer can go to directly
 	HomeMenu string`json:"homeMenu"`
 	Menus map[string]*MenuItemList `json:"menus"`
 	Keyboards map[string][]*MenuKeycodeBinding `json:"keyboards"`
+	Append map[string][]*MenuItem `json:"append"` //items to add to the end of menus defined by other files, such as an entry in the home menu
 }
 type MenuKeycodeBinding struct {
 	Keycode   uint16 `json:"keycode"`
@@ -30,27 +35,39 @@ type MenuKeycodeBinding struct {
 
 var (
 	configFile string //path to menu configuration
+	menuDir string //directory of menu configuration drop-ins, merged after the menu configuration
 	keyCalibrationFile string //path to keyboard calibration, can be written for embedded devices or generated by first run calibrator
-	hLines int//horizontal lines for screen
-	vLines int//vertical lines for screen
+	hLines int//columns available on screen
+	vLines int//lines available on screen
 	workingDir string //working directory for menu assets
+	inputMode string //input backend, evdev, tty or auto for both
+	stateFile string //path to save vars to between sessions
+	resume bool //return to the last menu from the state file at startup
 
 	keyCalibration map[string][]*MenuKeycodeBinding = make(map[string][]*MenuKeycodeBinding)
 	menuConfig *MenuConfig //menu configuration
 	menuEngine *MenuEngine //menu engine/runtime/???
+	menuState *StateFile //menu state saved between sessions, if enabled
 )
 
-func init() {
+//setup applies the command-line flags, then loads the key calibration and the menu configuration into the menu engine
+func setup() {
 	//Apply all command-line flags
 	flag.StringVar(&configFile, "menu", "/etc/jdtoolbox/menu.json", "path to menu configuration")
+	flag.StringVar(&menuDir, "menuDir", "", "directory of *.json menu configurations to merge in, menus.d next to the menu configuration if empty")
 	flag.StringVar(&keyCalibrationFile, "keyCalibration", "/etc/jdtoolbox/keyCalibration.json", "path to keyboard calibration, generated by calibrator if not present")
-	flag.IntVar(&hLines, "hLines", 0, "horizontal lines available to virtual screen") //<= 0: unlimited
-	flag.IntVar(&vLines, "vLines", 0, "vertical lines available to virtual screen") //<= 0: unlimited
+	flag.IntVar(&hLines, "hLines", 0, "columns available to virtual screen, long items are cut to fit") //<= 0: unlimited
+	flag.IntVar(&vLines, "vLines", 0, "lines available to virtual screen, long menus scroll to fit") //<= 0: unlimited
 	flag.StringVar(&workingDir, "workingDir", "/", "the root directory of menu assets")
+	flag.StringVar(&inputMode, "input", "auto", "input backend: evdev for input devices, tty for arrow keys over stdin, or auto for both, falling back to whichever one works")
+	flag.StringVar(&stateFile, "state", "", "path to save vars to whenever they change and load them from at startup, disabled if empty")
+	flag.BoolVar(&resume, "resume", false, "return to the last menu and item from the state file at startup")
 	flag.Parse()
 
-	if vLines > 0 {
-		vLines += 15
+	switch inputMode {
+	case "auto", "evdev", "tty":
+	default:
+		panic("unknown input backend: " + inputMode)
 	}
 
 	keyCalibrationJSON, err := ioutil.ReadFile(keyCalibrationFile)
@@ -62,78 +79,107 @@ func init() {
 		}
 	}
 
-	configJSON, err := ioutil.ReadFile(configFile)
+	if menuDir == "" {
+		menuDir = filepath.Join(filepath.Dir(configFile), "menus.d")
+	}
+	menuConfig, err = LoadMenuConfig(configFile, menuDir)
 	if err != nil {
-		panic(fmt.Sprintf("error reading config file: %v", err))
+		panic(fmt.Sprintf("error loading config file: %v", err))
 	}
 
-	menuConfig = &MenuConfig{}
-	err = json.Unmarshal(configJSON, menuConfig)
+	menuEngine, err = loadEngine(menuConfig, render, hLines, vLines, workingDir)
 	if err != nil {
-		panic(fmt.Sprintf("error parsing config file: %v", err))
+		panic(err.Error())
 	}
 
-	menuEngine = NewMenuEngine(render, hLines, vLines)
-	menuEngine.Environment["WORKINGDIR"] = workingDir
-
-	for id, itemList := range menuConfig.Menus {
-		menuEngine.AddMenu(id, itemList)
+	if stateFile != "" {
+		menuState = NewStateFile(stateFile, menuConfig.Transient, menuConfig.Menus)
+		if err := menuState.Load(menuEngine); err != nil {
+			fmt.Fprintf(os.Stderr, "error loading state file, starting over: %v\n", err)
+		}
+		menuEngine.OnChange = append(menuEngine.OnChange, func() {
+			if err := menuState.Update(menuEngine); err != nil {
+				fmt.Fprintf(os.Stderr, "error saving state file: %v\n", err)
+			}
+		})
+		menuEngine.OnExit = append(menuEngine.OnExit, func() {
+			if err := menuState.Save(menuEngine); err != nil {
+				fmt.Fprintf(os.Stderr, "error saving state file: %v\n", err)
+			}
+		})
 	}
 
-	menuEngine.HomeMenu = menuConfig.HomeMenu
+	ids := make([]string, 0, len(menuEngine.Menus))
+	for id := range menuEngine.Menus {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if unknown := menuEngine.UnknownVars(id); len(unknown) > 0 {
+			fmt.Fprintf(os.Stderr, "warning: menu %s uses unknown vars: %s\n", id, strings.Join(unknown, ", "))
+		}
+	}
+}
 
-	//DEPRECATED, move embedded keyboards to key calibrator
-	if menuConfig.Keyboards != nil && len(menuConfig.Keyboards) > 0 {
-		for keyboard, bindings := range menuConfig.Keyboards {
-			kl, err := NewKeycodeListener(keyboard)
-			if err != nil {
-				panic(fmt.Sprintf("error listening to keyboard %s: %v", keyboard, err))
-			}
-			for _, binding := range bindings {
-				var action func()
-				switch binding.Action {
-					case "prevItem":
-						action = menuEngine.PrevItem
-					case "nextItem":
-						action = menuEngine.NextItem
-					case "selectItem":
-						action = menuEngine.Action
-					default:
-						panic("unknown action: " + binding.Action)
-				}
-				kl.Bind(binding.Keycode, binding.OnRelease, action)
+//bindInput starts listening to the input backend, where auto listens to both the terminal and the input devices
+//
+//Auto only needs one of them to work, and without a terminal it's the same as evdev, calibrating the input devices if there's no key calibration yet
+func bindInput() {
+	var termErr error
+	if inputMode != "evdev" {
+		termErr = bindTerminal()
+		if inputMode == "tty" {
+			if termErr != nil {
+				panic(fmt.Sprintf("error listening to terminal: %v", termErr))
 			}
-			go kl.Run()
+			return
 		}
 	}
 
-	if keyCalibration != nil && len(keyCalibration) > 0 {
-		bindKeys()
+	//DEPRECATED, move embedded keyboards to key calibrator
+	keyboards := bindKeys(menuConfig.Keyboards)
+	keyboards += bindKeys(keyCalibration)
+
+	if inputMode == "auto" && termErr != nil {
+		if keyboards == 0 && len(menuConfig.Keyboards)+len(keyCalibration) > 0 {
+			panic(fmt.Sprintf("error listening to terminal: %v, and no keyboards could be listened to", termErr))
+		}
+		inputMode = "evdev"
 	}
 }
 
-func bindKeys() {
-	for keyboard, bindings := range keyCalibration {
+//bindKeys listens to keyboards for their key bindings, returning how many keyboards are listened to
+//
+//A keyboard that can't be listened to panics, unless the input backend is auto where it's skipped with a warning
+func bindKeys(keyboards map[string][]*MenuKeycodeBinding) int {
+	bound := 0
+	for keyboard, bindings := range keyboards {
 		kl, err := NewKeycodeListener(keyboard)
 		if err != nil {
-			panic(fmt.Sprintf("error listening to keyboard %s: %v", keyboard, err))
+			if inputMode != "auto" {
+				panic(fmt.Sprintf("error listening to keyboard %s: %v", keyboard, err))
+			}
+			fmt.Fprintf(os.Stderr, "warning: error listening to keyboard %s: %v\n", keyboard, err)
+			continue
 		}
 		for _, binding := range bindings {
 			var action func()
 			switch binding.Action {
 				case "prevItem":
-					action = menuEngine.PrevItem
+					action = menuEngine.Handler(menuEngine.PrevItem)
 				case "nextItem":
-					action = menuEngine.NextItem
+					action = menuEngine.Handler(menuEngine.NextItem)
 				case "selectItem":
-					action = menuEngine.Action
+					action = menuEngine.Handler(menuEngine.Action)
 				default:
 					panic("unknown action: " + binding.Action)
 			}
 			kl.Bind(binding.Keycode, binding.OnRelease, action)
 		}
 		go kl.Run()
+		bound++
 	}
+	return bound
 }
 
 type KeyCalibration struct {
@@ -161,8 +207,11 @@ func (kc *KeyCalibration) Input(keyboard string, keycode uint16, onRelease bool)
 }
 
 func main() {
+	setup()
+	bindInput()
+
 	//Generate a key calibration file if one doesn't exist yet
-	if _, err := os.Stat(keyCalibrationFile); err != nil {
+	if _, err := os.Stat(keyCalibrationFile); inputMode == "evdev" && err != nil {
 		calibrator := &KeyCalibration{}
 
 		//Get a list of keyboards
@@ -248,7 +297,7 @@ func main() {
 					fmt.Println("")
 					fmt.Println("Saved results:", keyCalibrationFile)
 					//fmt.Println(string(keyboards))
-					bindKeys()
+					bindKeys(keyCalibration)
 					time.Sleep(time.Second * 2)
 					//calibrator.Ready = false
 			}
@@ -256,16 +305,67 @@ func main() {
 	}
 
 	clear(5)
-	menuEngine.Home()
+	menuEngine.Do(func() {
+		if !resume || menuState == nil || !menuState.Resume(menuEngine) {
+			menuEngine.Home()
+		}
+	})
 
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT)
 	<-sc
+	menuEngine.Do(func() { menuEngine.Exit(0) })
+}
+
+//loadEngine returns a menu engine holding the menus of a configuration, after checking their conditions and dynamic menus
+func loadEngine(config *MenuConfig, renderer func(string), width, height int, workingDir string) (*MenuEngine, error) {
+	me := NewMenuEngine(renderer, width, height)
+	for name, value := range config.Environment {
+		me.Environment[name] = value
+	}
+	me.Environment["WORKINGDIR"] = workingDir
+
+	for id, itemList := range config.Menus {
+		for _, item := range itemList.Items {
+			for _, expr := range []string{item.VisibleIf, item.EnabledIf} {
+				if _, err := parseConditions(expr); expr != "" && err != nil {
+					return nil, fmt.Errorf("error parsing condition of item %s in menu %s: %v", item.Name, id, err)
+				}
+			}
+		}
+		if itemList.Dynamic != nil {
+			if err := itemList.Dynamic.Validate(); err != nil {
+				return nil, fmt.Errorf("error in menu %s: %v", id, err)
+			}
+		}
+		itemList.templated = true
+		me.AddMenu(id, itemList)
+	}
+
+	me.HomeMenu = config.HomeMenu
+	me.Bookmarks = config.Bookmarks
+	return me, nil
+}
+
+//bindTerminal listens to stdin for key presses, returning an error if it isn't a terminal
+func bindTerminal() error {
+	tl, err := NewTerminalListener(os.Stdin)
+	if err != nil {
+		return err
+	}
+	tl.Bind(TermUp, menuEngine.Handler(menuEngine.PrevItem))
+	tl.Bind(TermDown, menuEngine.Handler(menuEngine.NextItem))
+	tl.Bind(TermSelect, menuEngine.Handler(menuEngine.Action))
+	tl.Bind(TermBack, menuEngine.Handler(menuEngine.PrevMenu))
+	tl.Bind(TermQuit, menuEngine.Handler(func() { menuEngine.Exit(0) }))
+	menuEngine.OnExit = append(menuEngine.OnExit, tl.Close)
+	go tl.Run()
+	return nil
 }
 
 func render(menu string) {
 	clear(0)
-	fmt.Printf("  " + menu)
+	fmt.Printf("  %s", menu)
 	fmt.Printf("\n\n\n")
 }
 
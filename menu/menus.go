@@ -1,414 +1,569 @@
 package main
 
 import (
-    "fmt"
-    "io/ioutil"
-    "os"
-    "os/exec"
-    "strconv"
-    "strings"
-    "time"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 //MenuItem holds an item for a menu, such as a button, a checkbox, or an input box
 type MenuItem struct {
-    Name   string `json:"name"`
-    Type   string `json:"type"`   //menu, exec, explorer[:pwd], note, var name
-    Action string `json:"action"` //var: string[:limit]|number[:min[:max]]|file[:extension1[,extension2,...]]|bool|opts:opt1,opt2,[opt3,...]
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`                //menu, exec, explorer[:pwd], backups[:dir], note, var name, pick name [auto=value]
+	Action    string   `json:"action"`              //var: string[:limit]|number[:min[:max]]|file[:extension1[,extension2,...]]|bool|opts:opt1,opt2,[opt3,...]
+	Argv      []string `json:"argv,omitempty"`      //exec and pick: arguments to run instead of splitting action, each var expanding within its argument; file: the file action and its paths, used as is
+	Confirm   string   `json:"confirm,omitempty"`   //asked as a yes or no question before the action runs, with vars replaced
+	VisibleIf string   `json:"visibleIf,omitempty"` //condition to show the item, see parseConditions
+	EnabledIf string   `json:"enabledIf,omitempty"` //condition to select the item, which is dimmed otherwise
 }
 
 //MenuItemList holds a list of items to interact with
 type MenuItemList struct {
-    Title string            `json:"title"`
-    Items []*MenuItem       `json:"items"` //items to display on the page
+	Title   string       `json:"title"`
+	Items   []*MenuItem  `json:"items"`             //items to display on the page
+	Dynamic *MenuDynamic `json:"dynamic,omitempty"` //where more items are listed from every time the menu is entered
+
+	templated bool                //the title and item texts have vars replaced, as they come from the configuration rather than being generated
+	reload    func(*MenuItemList) //lists a generated menu again when it's returned to, such as the explorer after a file was deleted
+	static    []*MenuItem         //the items of a dynamic menu that aren't listed from its command or file
+	listing   chan struct{}       //closed to cancel the command listing the items of a dynamic menu, nil once it's done
 }
 
 func (m *MenuItemList) AddItem(name, itemType, action string) {
-    m.Items = append(m.Items, &MenuItem{Name: name, Type: itemType, Action: action})
+	m.Items = append(m.Items, &MenuItem{Name: name, Type: itemType, Action: action})
+}
+
+//text returns a title or item text of the menu as shown, with vars replaced if it comes from the configuration
+//
+//Generated menus are shown as is, so file names and typed values holding a $ aren't mistaken for vars
+func (m *MenuItemList) text(me *MenuEngine, text string) string {
+	if !m.templated {
+		return text
+	}
+	return me.Vars(text)
 }
 
 //MenuEngine holds a list of menus and acts as the menu interface
 type MenuEngine struct {
-    //Menu navigation
-    Menus       map[string]*MenuItemList
-    HomeMenu    string
-    LoadedMenu  string
-    MenuHistory []string
-    ItemHistory []int
-    Environment map[string]string //global variables set by menus
-    ItemCursor  int
-    Locked      bool
-    Return      string //return value set by some menu types
-
-    //Rendering control
-    Render func(string)
-    LinesH int
-    LinesV int
+	//Menu navigation
+	Menus       map[string]*MenuItemList
+	HomeMenu    string
+	LoadedMenu  string
+	MenuHistory []string
+	ItemHistory []int
+	Environment map[string]string //global variables set by menus
+	ItemCursor  int
+	Return      string            //return value set by some menu types
+	confirming  *MenuItem         //the item a confirm dialog asks about, whose action runs once it's answered yes
+	probes      map[string]*probe //results of probe conditions, until another menu is entered
+	builtins    map[string]string //built-in vars such as SLOT, read from the device once
+	input       *MenuInput        //the on-screen keyboard state, set by string vars
+	explorer    *ExplorerState    //the directory the explorer is in, and the ones to go back to
+	Bookmarks   []*Bookmark       //directories the explorer can go to directly
+	scroll      int               //first item rendered when the menu is too long for the screen
+	scrollMenu  string            //the menu scroll belongs to
+
+	//Input control
+	dispatch sync.Mutex //held while a handler runs, see Do, and never while a command runs as commands run in the background
+
+	//Cleanup control
+	OnExit   []func() //called before exiting, such as to restore the terminal
+	OnChange []func() //called after every handler run through Do, such as to save the state
+
+	//Exec control
+	Runner  func(cmdLine []string, stdout, stderr io.Writer, cancel <-chan struct{}) (int, error) //runs every command line until it exits or cancel is closed, returning the exit code, or runs them as commands if nil
+	job     *Job                                                                                  //the last exec item, shown in the output pane
+	pending sync.WaitGroup                                                                        //commands running in the background outside of the output pane, see Command
+
+	//Rendering control
+	renderPending int32 //set while output waits to be rendered, see renderLater
+	Render        func(string)
+	LinesH        int
+	LinesV        int
 }
 
 //NewMenuEngine returns a menu engine ready to be used
 func NewMenuEngine(renderer func(string), width, height int) *MenuEngine {
-    return &MenuEngine{
-        Menus:       make(map[string]*MenuItemList),
-        MenuHistory: make([]string, 0),
-        ItemHistory: make([]int, 0),
-        Environment: make(map[string]string),
-        Render:      renderer,
-        LinesH:      width,
-        LinesV:      height,
-    }
+	return &MenuEngine{
+		Menus:       make(map[string]*MenuItemList),
+		MenuHistory: make([]string, 0),
+		ItemHistory: make([]int, 0),
+		Environment: make(map[string]string),
+		Render:      renderer,
+		LinesH:      width,
+		LinesV:      height,
+	}
 }
 
 func (me *MenuEngine) LoadMenu(id string, itemList *MenuItemList) {
-    me.Menus[id] = itemList
-}
-
-func (me *MenuEngine) Lock() {
-    me.Locked = true
-}
-func (me *MenuEngine) Unlock() {
-    me.Locked = false
+	me.Menus[id] = itemList
 }
 
 func (me *MenuEngine) init() {
-    if me.Menus == nil {
-        me.Menus = make(map[string]*MenuItemList)
-    }
-    if me.MenuHistory == nil {
-        me.MenuHistory = make([]string, 0)
-    }
-    if me.ItemHistory == nil {
-        me.ItemHistory = make([]int, 0)
-    }
-    if me.Environment == nil {
-    	me.Environment = make(map[string]string)
-    }
+	if me.Menus == nil {
+		me.Menus = make(map[string]*MenuItemList)
+	}
+	if me.MenuHistory == nil {
+		me.MenuHistory = make([]string, 0)
+	}
+	if me.ItemHistory == nil {
+		me.ItemHistory = make([]int, 0)
+	}
+	if me.Environment == nil {
+		me.Environment = make(map[string]string)
+	}
 }
 
 func (me *MenuEngine) isBackVisible() bool {
-    return len(me.MenuHistory) > 0
+	return len(me.MenuHistory) > 0
 }
 
 //PrevItem navigates to the previous menu item, or to the last if none previous
 func (me *MenuEngine) PrevItem() {
-    if me.Locked {
-        return
-    }
-    me.init()
-    defer me.render()
-
-    if me.isBackVisible() && me.ItemCursor == -1 {
-        me.ItemCursor = len(me.Menus[me.LoadedMenu].Items) - 1
-    } else if !me.isBackVisible() && me.ItemCursor == 0 {
-        me.ItemCursor = len(me.Menus[me.LoadedMenu].Items) - 1
-    } else {
-        me.ItemCursor--
-    }
-
-    if me.ItemCursor >= 0 && me.Menus[me.LoadedMenu].Items[me.ItemCursor].Type == "divider" {
-        me.PrevItem()
-    }
+	me.init()
+	if me.Menus[me.LoadedMenu] == nil {
+		return //Nothing loaded yet
+	}
+	defer me.render()
+
+	if me.LoadedMenu == execMenu {
+		me.scrollJob(-1)
+		return
+	}
+
+	//Skip dividers, hidden items and disabled items, giving up after going all the way around
+	items := me.Menus[me.LoadedMenu].Items
+	for i := 0; i <= len(items); i++ {
+		if me.isBackVisible() && me.ItemCursor == -1 {
+			me.ItemCursor = len(items) - 1
+		} else if !me.isBackVisible() && me.ItemCursor == 0 {
+			me.ItemCursor = len(items) - 1
+		} else {
+			me.ItemCursor--
+		}
+
+		if me.ItemCursor < 0 || me.ItemCursor >= len(items) || me.selectable(items[me.ItemCursor]) {
+			break
+		}
+	}
 }
 
 //NextItem navigates to the next menu item, or to the first if none next
 func (me *MenuEngine) NextItem() {
-    if me.Locked {
-        return
-    }
-    me.init()
-    defer me.render()
-
-    if (me.ItemCursor + 1) >= len(me.Menus[me.LoadedMenu].Items) {
-        if me.isBackVisible() {
-            me.ItemCursor = -1
-        } else {
-            me.ItemCursor = 0
-        }
-    } else {
-        me.ItemCursor++
-    }
-
-    if me.ItemCursor >= 0 && me.Menus[me.LoadedMenu].Items[me.ItemCursor].Type == "divider" {
-        me.NextItem()
-    }
+	me.init()
+	if me.Menus[me.LoadedMenu] == nil {
+		return //Nothing loaded yet
+	}
+	defer me.render()
+
+	if me.LoadedMenu == execMenu {
+		me.scrollJob(1)
+		return
+	}
+
+	//Skip dividers, hidden items and disabled items, giving up after going all the way around
+	items := me.Menus[me.LoadedMenu].Items
+	for i := 0; i <= len(items); i++ {
+		if (me.ItemCursor + 1) >= len(items) {
+			if me.isBackVisible() {
+				me.ItemCursor = -1
+			} else {
+				me.ItemCursor = 0
+			}
+		} else {
+			me.ItemCursor++
+		}
+
+		if me.ItemCursor < 0 || me.ItemCursor >= len(items) || me.selectable(items[me.ItemCursor]) {
+			break
+		}
+	}
 }
 
 //Action activates the selected item's action, such as navigating to a menu or executing a program
 func (me *MenuEngine) Action() {
-    if me.Locked {
-        return
-    }
-    me.init()
-
-    if me.ItemCursor == -1 {
-        me.PrevMenu()
-        return
-    }
-
-    selectedItem := me.Menus[me.LoadedMenu].Items[me.ItemCursor]
-    selectedAction := me.Vars(selectedItem.Action)
-    itemArgs := strings.Split(selectedItem.Type, " ")
-    switch itemArgs[0] {
-    case "internal":
-        switch selectedAction {
-        case "exit":
-            os.Exit(0)
-        default:
-            me.ErrorText("Unknown internal action: " + selectedAction)
-        }
-    case "menu":
-        me.ChangeMenu(selectedAction)
-    case "exec":
-        me.Lock()
-		defer me.Unlock()
-        cmdLine := strings.Split(selectedAction, " ")
-        cmd := exec.Command(cmdLine[0])
-        if len(cmdLine) > 1 {
-            cmd = exec.Command(cmdLine[0], cmdLine[1:]...)
-        }
-        cmd.Stdout = os.Stdout
-        cmd.Stdin = os.Stdin
-        cmd.Stderr = os.Stderr
-        err := cmd.Run()
-        if err != nil {
-        	fmt.Println(err)
-	        os.Exit(0)
-	    }
-	    time.Sleep(3 * time.Second)
-	    msg := "Task finished successfully!"
-	    if len(itemArgs) > 1 {
-	    	msg = strings.Join(itemArgs[1:], " ")
-	    }
-	   	me.ErrorText(msg)
-    case "explorer":
-        workingDir := "/"
-        if len(itemArgs) > 1 {
-            workingDir = strings.Join(itemArgs[1:], " ")
-        }
-        me.Explorer(workingDir, selectedAction)
-    case "return":
-    	if me.Return != "" {
-	    	me.Environment[me.Return] = selectedAction
-    		me.Return = ""
-    	}
-    	me.PrevMenu()
-   	    
-   	    //Back all the way out of an explorer context
-   		for {
-   			if string(me.Menus[me.LoadedMenu].Title[:8]) == "Explorer" {
-	    		me.PrevMenu()
-	    		continue
-	    	}
-	    	break
-   		}
-    case "setvar":
-    	me.Return = itemArgs[1] //set var for what to return to
-
-    	varAction := strings.Split(selectedAction, " ")
-    	switch varAction[0] {
-    	case "explorer":
-    		workingDir := "/"
-    		if len(varAction) > 1 {
-    			workingDir = strings.Join(varAction[1:], " ")
-    		}
-    		me.Explorer(workingDir, "")
-    	default:
-    		me.ErrorText("Unknown action for var " + me.Return + ": " + selectedAction)
-    	}
-    case "note":
-        if selectedAction != "" {
-            me.ErrorText(selectedAction)
-        } //Do nothing if it's just a note, show extended information if provided
-    default:
-        me.ErrorText("Unknown action: " + selectedItem.Type + ":" + selectedAction)
-    }
+	me.init()
+	if me.Menus[me.LoadedMenu] == nil {
+		return //Nothing loaded yet
+	}
+
+	if me.LoadedMenu == execMenu {
+		me.jobAction()
+		return
+	}
+	if me.ItemCursor == -1 {
+		me.PrevMenu()
+		return
+	}
+	if me.ItemCursor < 0 || me.ItemCursor >= len(me.Menus[me.LoadedMenu].Items) {
+		return //Nothing to select, such as on an error message
+	}
+
+	selectedItem := me.Menus[me.LoadedMenu].Items[me.ItemCursor]
+	if !me.selectable(selectedItem) {
+		return //Disabled or hidden since the cursor was put on it
+	}
+	if selectedItem.Confirm != "" {
+		me.confirming = selectedItem
+		me.Confirm(me.Menus[me.LoadedMenu].text(me, selectedItem.Confirm))
+		return
+	}
+	me.act(selectedItem)
 }
 
-//Explorer abuses the powers of AddMenu, ChangeMenu, and PrevMenu to create a file browser with support for passing a selected file to an executable
-func (me *MenuEngine) Explorer(workingDir, bin string) {
-    displayBin := workingDir
-    if bin != "" {
-        displayBin = strings.Replace(bin, "$?", workingDir, -1)
-    }
-    explorer := &MenuItemList{
-        Title: "Explorer - " + displayBin,
-        Items: make([]*MenuItem, 0),
-    }
-
-    dirStat, err := os.Stat(workingDir)
-    if err != nil {
-        switch {
-            case os.IsNotExist(err):
-                explorer.AddItem("Path " + workingDir + " does not exist!", "note", "")
-            case os.IsExist(err):
-                explorer.AddItem("Path " + workingDir + " is not accessible!", "note", "")
-            default:
-                explorer.AddItem("Path " + workingDir + " has unknown errors!", "note", fmt.Sprintf("%v", err))
-        }
-    } else {
-        mode := dirStat.Mode()
-        switch {
-            case mode.IsDir(): //Directory
-                files, err := ioutil.ReadDir(workingDir)
-                if err != nil {
-                    explorer.AddItem("Path " + workingDir + " has unreadable file contents!", "note", fmt.Sprintf("%v", err))
-                } else {
-                    for _, file := range files {
-                        fileStat, err := os.Stat(workingDir + file.Name())
-                        if err == nil {
-                            switch {
-                                case fileStat.IsDir():
-                                    explorer.AddItem(file.Name() + "/", "explorer " + workingDir + file.Name() + "/", bin)
-                                default:
-                                	if bin != "" {
-	                                    explorer.AddItem(file.Name(), "exec", strings.Replace(bin, "$?", fmt.Sprintf("%s%s", workingDir, file.Name()), -1))
-	                                } else {
-	                                	explorer.AddItem(file.Name(), "return", workingDir + file.Name())
-	                                }
-                            }
-                        }
-                    }
-                }
-            default: //File
-                explorer.AddItem("You got pretty far into the switch nest!", "note", "")
-        }
-    }
-
-    me.AddMenu(workingDir, explorer)
-    me.ChangeMenu(workingDir)
+//act runs the action of an item of the loaded menu, once it's selected and confirmed
+func (me *MenuEngine) act(selectedItem *MenuItem) {
+	text := me.Menus[me.LoadedMenu].text
+	selectedAction := text(me, selectedItem.Action)
+	itemArgs := strings.Split(selectedItem.Type, " ")
+	switch itemArgs[0] {
+	case "internal":
+		switch selectedAction {
+		case "exit":
+			me.Exit(0)
+		default:
+			me.ErrorText("Unknown internal action: " + selectedAction)
+		}
+	case "menu":
+		me.ChangeMenu(selectedAction)
+	case "exec":
+		msg := "Task finished successfully!"
+		if len(itemArgs) > 1 {
+			msg = strings.Join(itemArgs[1:], " ")
+		}
+		cmdLine, err := me.Argv(selectedItem)
+		if err != nil || len(cmdLine) == 0 {
+			me.ErrorText(fmt.Sprintf("Unable to run %s: %v", selectedItem.Name, err))
+			return
+		}
+		me.Exec(cmdLine, msg)
+	case "explorer":
+		workingDir, opts, err := parseExplorer(itemArgs[1:])
+		if err != nil {
+			me.ErrorText(err.Error())
+			return
+		}
+		me.Explorer(workingDir, selectedItem.Action, opts) //Vars are expanded once a file is picked
+	case "backups":
+		storeDir := "/sdcard/jdtoolbox/backups"
+		if len(itemArgs) > 1 {
+			storeDir = strings.Join(itemArgs[1:], " ")
+		}
+		me.Backups(storeDir, selectedItem.Action) //Vars are expanded once a backup is restored
+	case "backup":
+		if len(selectedItem.Argv) != 2 {
+			me.ErrorText("Missing backup manifest or restore command")
+			break
+		}
+		me.Backup(selectedItem.Argv[0], selectedItem.Argv[1])
+	case "file":
+		me.File(selectedItem.Argv)
+	case "return":
+		if me.Return != "" {
+			me.Environment[me.Return] = selectedItem.Action
+			me.Return = ""
+		}
+		if me.LoadedMenu != explorerMenu {
+			me.PrevMenu() //Back from a menu opened from the explorer too, such as the actions of a file
+		}
+		me.closeExplorer()
+	case "cd":
+		me.Cd(selectedItem.Action) //Paths are used as is
+	case "jump":
+		me.Jump()
+	case "setvar":
+		if len(itemArgs) < 2 {
+			me.ErrorText("Missing variable name for item: " + selectedItem.Name)
+			return
+		}
+		varAction, err := me.Args(selectedItem.Action)
+		if err != nil {
+			me.ErrorText(fmt.Sprintf("Unable to split action for var %s: %v", itemArgs[1], err))
+			return
+		}
+		if len(varAction) == 0 {
+			varAction = []string{""}
+		}
+		me.Return = itemArgs[1] //set var for what to return to
+
+		switch varAction[0] {
+		case "explorer":
+			workingDir, opts, err := parseExplorer(varAction[1:])
+			if err != nil {
+				me.ErrorText(err.Error())
+				return
+			}
+			me.Explorer(workingDir, "", opts)
+		default:
+			me.ErrorText("Unknown action for var " + me.Return + ": " + selectedAction)
+		}
+	case "var":
+		if len(itemArgs) < 2 {
+			me.ErrorText("Missing variable name for item: " + selectedItem.Name)
+			return
+		}
+		me.Var(itemArgs[1], selectedAction)
+	case "pick":
+		if len(itemArgs) < 2 {
+			me.ErrorText("Missing variable name for item: " + selectedItem.Name)
+			return
+		}
+		cmdLine, err := me.Argv(selectedItem)
+		if err != nil || len(cmdLine) == 0 {
+			me.ErrorText(fmt.Sprintf("Unable to list choices for %s: %v", itemArgs[1], err))
+			return
+		}
+		auto := ""
+		for _, opt := range itemArgs[2:] {
+			kv := strings.SplitN(opt, "=", 2)
+			if len(kv) != 2 || kv[0] != "auto" {
+				me.ErrorText("Unknown pick option for item " + selectedItem.Name + ": " + opt)
+				return
+			}
+			auto = kv[1]
+		}
+		me.Pick(itemArgs[1], auto, cmdLine)
+	case "input":
+		if len(itemArgs) < 2 {
+			me.ErrorText("Missing input action for item: " + selectedItem.Name)
+			return
+		}
+		me.InputAction(itemArgs[1], selectedItem.Action)
+	case "confirm":
+		confirmed := me.confirming
+		me.confirming = nil
+		me.PrevMenu()
+		if selectedAction == "yes" && confirmed != nil {
+			me.act(confirmed) //The item asked about, even if the menu was listed again and it moved or went away
+		}
+	case "note":
+		if selectedAction != "" {
+			me.ErrorText(selectedAction)
+		} //Do nothing if it's just a note, show extended information if provided
+	default:
+		me.ErrorText("Unknown action: " + selectedItem.Type + ":" + selectedAction)
+	}
 }
 
 //AddMenu adds a menu to the menu list
 func (me *MenuEngine) AddMenu(menuID string, menu *MenuItemList) {
-    me.init()
-    me.Menus[menuID] = menu
+	me.init()
+	me.Menus[menuID] = menu
 }
 
 //RemoveMenu removes a menu from the menu list
 func (me *MenuEngine) RemoveMenu(menuID string) {
-    me.init()
-    me.Menus[menuID] = nil
+	me.init()
+	me.Menus[menuID] = nil
 }
 
 //ChangeMenu changes to another available menu
 func (me *MenuEngine) ChangeMenu(menuID string) {
-    me.init()
-    defer me.render()
-
-    _, ok := me.Menus[menuID]
-    if !ok {
-        me.ErrorText("Unknown menu: " + menuID)
-        return
-    }
-
-    if me.LoadedMenu != "" { //&& me.LoadedMenu != "INTERNAL_ERROR_TEXT" {
-        me.MenuHistory = append(me.MenuHistory, me.LoadedMenu)
-        me.ItemHistory = append(me.ItemHistory, me.ItemCursor)
-    }
-
-    me.LoadedMenu = menuID
-    me.ItemCursor = 0
-    if me.isBackVisible() {
-        me.ItemCursor = -1
-    }
+	me.init()
+	defer me.render()
+
+	_, ok := me.Menus[menuID]
+	if !ok {
+		me.ErrorText("Unknown menu: " + menuID)
+		return
+	}
+
+	if me.LoadedMenu != "" { //&& me.LoadedMenu != "INTERNAL_ERROR_TEXT" {
+		me.MenuHistory = append(me.MenuHistory, me.LoadedMenu)
+		me.ItemHistory = append(me.ItemHistory, me.ItemCursor)
+	}
+
+	if menu := me.Menus[menuID]; menu != nil && menu.Dynamic != nil {
+		me.loadDynamic(menu)
+	}
+
+	me.LoadedMenu = menuID
+	me.probes = nil
+	me.ItemCursor = 0
+	if me.isBackVisible() {
+		me.ItemCursor = -1
+	} else {
+		items := me.Menus[menuID].Items
+		for me.ItemCursor < len(items)-1 && !me.selectable(items[me.ItemCursor]) {
+			me.ItemCursor++
+		}
+	}
+}
+
+//Exit cancels the running exec item, runs every exit handler and exits with code
+func (me *MenuEngine) Exit(code int) {
+	if me.job != nil && me.job.Running {
+		me.job.Cancel()
+		select {
+		case <-me.job.exited:
+		case <-time.After(killGrace + time.Second):
+		}
+	}
+	for _, handler := range me.OnExit {
+		handler()
+	}
+	os.Exit(code)
 }
 
 //Home returns to the home menu
 func (me *MenuEngine) Home() {
-    me.ChangeMenu(me.HomeMenu)
+	me.ChangeMenu(me.HomeMenu)
 }
 
 //PrevMenu returns to the last menu in history
 func (me *MenuEngine) PrevMenu() {
-    me.init()
-    defer me.render()
-
-    if len(me.MenuHistory) == 0 {
-        return //We can't go back to nothing, or can we?
-    }
-
-    menuID := me.MenuHistory[len(me.MenuHistory)-1]         //Get the previous menu
-    me.MenuHistory = me.MenuHistory[:len(me.MenuHistory)-1] //Remove this menu from history regardless of it being valid
-    itemCursor := me.ItemHistory[len(me.ItemHistory)-1]     //Get the previous item cursor
-    me.ItemHistory = me.ItemHistory[:len(me.ItemHistory)-1] //Remove this item cursor from history regardless of it being valid
-
-    _, ok := me.Menus[menuID]
-    if !ok {
-        //Allow returning to a working menu
-        me.MenuHistory = append(me.MenuHistory, me.LoadedMenu)
-        me.ItemHistory = append(me.ItemHistory, me.ItemCursor)
-
-        me.ErrorText("Unknown menu: " + menuID)
-        return
-    }
-
-    //Reset the item cursor if it's out of bounds
-    if itemCursor >= len(me.Menus[menuID].Items) {
-        me.ItemCursor = 0
-    }
-
-    me.LoadedMenu = menuID
-    me.ItemCursor = itemCursor
+	me.init()
+	defer me.render()
+
+	if me.LoadedMenu == execMenu && me.job != nil && me.job.Running {
+		me.job.Cancel() //Leaving a running exec item cancels it
+		return
+	}
+	if me.LoadedMenu == explorerMenu && me.explorerBack() {
+		return //Back to the last directory, the explorer is left once it's back where it was opened
+	}
+	if len(me.MenuHistory) == 0 {
+		return //We can't go back to nothing, or can we?
+	}
+
+	menuID := me.MenuHistory[len(me.MenuHistory)-1]         //Get the previous menu
+	me.MenuHistory = me.MenuHistory[:len(me.MenuHistory)-1] //Remove this menu from history regardless of it being valid
+	itemCursor := me.ItemHistory[len(me.ItemHistory)-1]     //Get the previous item cursor
+	me.ItemHistory = me.ItemHistory[:len(me.ItemHistory)-1] //Remove this item cursor from history regardless of it being valid
+
+	_, ok := me.Menus[menuID]
+	if !ok {
+		//Allow returning to a working menu
+		me.MenuHistory = append(me.MenuHistory, me.LoadedMenu)
+		me.ItemHistory = append(me.ItemHistory, me.ItemCursor)
+
+		me.ErrorText("Unknown menu: " + menuID)
+		return
+	}
+
+	me.refresh(me.Menus[menuID])
+
+	//Reset the item cursor if it's out of bounds, unless the items are still being listed, which keeps it in bounds once they are
+	if itemCursor >= len(me.Menus[menuID].Items) && me.Menus[menuID].listing == nil {
+		itemCursor = 0
+	}
+
+	me.LoadedMenu = menuID
+	me.probes = nil
+	me.ItemCursor = itemCursor
 }
 
 //ErrorText generates an error message menu with menuID "INTERNAL_ERROR_TEXT" and navigates to it
 //It is used internally as well as being made available, so refrain from using menuIDs starting with "INTERNAL"
 func (me *MenuEngine) ErrorText(err string) {
-    menuError := &MenuItemList{
-        Title: err,
-    }
-    me.Menus["INTERNAL_ERROR_TEXT"] = menuError
-    me.ChangeMenu("INTERNAL_ERROR_TEXT")
+	menuError := &MenuItemList{
+		Title: err,
+	}
+	me.Menus["INTERNAL_ERROR_TEXT"] = menuError
+	me.ChangeMenu("INTERNAL_ERROR_TEXT")
+}
+
+//Confirm generates a yes or no question menu with menuID "INTERNAL_CONFIRM" and navigates to it, with No selected
+//Answering returns to the previous menu, running the action of the selected item there if the answer was yes
+func (me *MenuEngine) Confirm(question string) {
+	confirm := &MenuItemList{
+		Title: question,
+	}
+	confirm.AddItem("No", "confirm", "no")
+	confirm.AddItem("Yes", "confirm", "yes")
+	me.Menus["INTERNAL_CONFIRM"] = confirm
+	me.ChangeMenu("INTERNAL_CONFIRM")
+	me.ItemCursor = 0
+	me.render()
 }
 
 //GetRender returns a rendered menu text to be displayed immediately, as the menu state can change freely before and after
+//Menus longer than LinesV scroll to follow the item cursor, and items are cut to fit LinesH
 func (me *MenuEngine) GetRender() string {
-    menu := ""
-
-    lm := me.Menus[me.LoadedMenu]
-    menu += "- " + lm.Title + "\n\n\n"
-    if me.isBackVisible() {
-        if me.ItemCursor == -1 {
-            menu += "   --> Go back\n"
-        } else {
-            menu += "      Go back\n"
-        }
-	menu += "\n"
-    }
-    if len(lm.Items) > 0 {
-        for i := 0; i < len(lm.Items); i++ {
-            switch lm.Items[i].Type {
-            case "divider":
-                if length, err := strconv.Atoi(lm.Items[i].Action); err != nil {
-                    for j := 0; j < length; j++ {
-                        menu += "\n"
-                    }
-                } else {
-                    menu += "\n"
-                }
-            default:
-                if me.ItemCursor == i {
-                    menu += "   --> " + lm.Items[i].Name + "\n"
-                } else {
-                    menu += "      " + lm.Items[i].Name + "\n"
-                }
-            }
-        }
-    }
-
-    return me.Vars(menu)
-}
+	menu := ""
 
-//Vars returns a string formatted with all vars replaced
-func (me *MenuEngine) Vars(in string) string {
-	for varName, varValue := range me.Environment {
-		in = strings.Replace(in, "$" + varName, varValue, -1)
+	if me.LoadedMenu == execMenu && me.job != nil {
+		return me.renderJob(me.job)
+	}
+	lm := me.Menus[me.LoadedMenu]
+	title := wrap(lm.text(me, lm.Title), me.LinesH-4)
+	menu += "- " + strings.Join(title, "\n") + "\n\n\n"
+	lines := me.LinesV - viewportMargin - len(title) - 2
+	if me.isBackVisible() {
+		if me.ItemCursor == -1 {
+			menu += "   --> Go back\n"
+		} else {
+			menu += "      Go back\n"
+		}
+		menu += "\n"
+		lines -= 2
 	}
-	return in
+
+	start, end := 0, len(lm.Items)
+	scrolling := false
+	if me.LinesV > 0 {
+		total := 0
+		for _, item := range lm.Items {
+			total += me.itemLines(item)
+		}
+		if total > lines {
+			scrolling = true
+			lines -= 2 //Room for the markers
+			if lines < 1 {
+				lines = 1
+			}
+			start, end = me.viewport(lm.Items, lines)
+		}
+	}
+
+	if scrolling {
+		if above := me.countItems(lm.Items[:start]); above > 0 {
+			menu += "      ^ " + strconv.Itoa(above) + " more above\n"
+		} else {
+			menu += "\n"
+		}
+	}
+	for i := start; i < end; i++ {
+		switch {
+		case !me.visible(lm.Items[i]):
+			continue
+		case lm.Items[i].Type == "divider":
+			for j := 0; j < me.itemLines(lm.Items[i]); j++ {
+				menu += "\n"
+			}
+		case me.checking(lm.Items[i]):
+			menu += "      " + dim(truncate(lm.text(me, lm.Items[i].Name)+" (checking...)", me.LinesH-itemIndent)) + "\n"
+		case !me.enabled(lm.Items[i]):
+			menu += "      " + dim(truncate(lm.text(me, lm.Items[i].Name), me.LinesH-itemIndent)) + "\n"
+		default:
+			name := truncate(lm.text(me, lm.Items[i].Name), me.LinesH-itemIndent)
+			if me.ItemCursor == i {
+				menu += "   --> " + name + "\n"
+			} else {
+				menu += "      " + name + "\n"
+			}
+		}
+	}
+	if scrolling {
+		footer := ""
+		if below := me.countItems(lm.Items[end:]); below > 0 {
+			footer = "v " + strconv.Itoa(below) + " more below"
+		}
+		if me.ItemCursor >= 0 {
+			footer += "  (" + strconv.Itoa(me.countItems(lm.Items[:me.ItemCursor+1])) + "/" + strconv.Itoa(me.countItems(lm.Items)) + ")"
+		}
+		menu += "      " + strings.TrimSpace(footer) + "\n"
+	}
+
+	return menu
 }
 
 func (me *MenuEngine) render() {
-    if me.Render != nil {
-        me.Render(me.GetRender())
-    }
+	if me.Render != nil {
+		me.Render(me.GetRender())
+	}
 }
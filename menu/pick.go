@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/JoshuaDoes/json"
+)
+
+//pickTimeout is how long the command of a pick item may run before its choices are given up on
+const pickTimeout = 10 * time.Second
+
+//MenuChoice holds a single choice printed by the command of a pick item
+type MenuChoice struct {
+	Name  string `json:"name"`  //what the choice is displayed as
+	Value string `json:"value"` //what the var is set to when the choice is picked
+}
+
+//Pick runs a command line that prints a JSON list of choices in the output pane, then lists them so the picked value is stored in a var
+//
+//If auto isn't empty, an Automatic choice setting the var to auto is listed first, such as for tools that can pick on their own
+//What the command writes to stderr stays in the pane if it fails, such as when the tool can't find anything to pick from
+func (me *MenuEngine) Pick(name, auto string, cmdLine []string) {
+	runner := me.runner()
+	var choices []*MenuChoice
+	job := &Job{CmdLine: cmdLine, Title: "$ " + shellJoin(cmdLine)}
+	job.then = func() {
+		if choices == nil {
+			return //The choices couldn't be parsed, which the pane shows
+		}
+		//Replace the output pane with the choices without entering the menu below it, so its probes and listing don't run for nothing
+		last := len(me.MenuHistory) - 1
+		me.LoadedMenu, me.ItemCursor = me.MenuHistory[last], me.ItemHistory[last]
+		me.MenuHistory, me.ItemHistory = me.MenuHistory[:last], me.ItemHistory[:last]
+		me.pick(name, auto, choices)
+	}
+	me.startJob(job, func(job *Job, output io.Writer, cancel <-chan struct{}) (int, error) {
+		var stdout bytes.Buffer
+		stop, release := withTimeout(cancel, pickTimeout)
+		defer release()
+		code, err := runner(cmdLine, &stdout, output, stop)
+		if err != nil || code != 0 {
+			select {
+			case <-stop:
+				fmt.Fprintf(output, "Gave up listing choices after %s\n", pickTimeout)
+			default:
+			}
+			return code, err
+		}
+
+		parsed := make([]*MenuChoice, 0)
+		if err := json.Unmarshal(stdout.Bytes(), &parsed); err != nil {
+			fmt.Fprintf(output, "%v\n\n%s", err, stdout.String())
+			job.Message = "Failed to list choices for " + name
+			return 0, nil
+		}
+		choices = parsed
+		return 0, nil
+	})
+}
+
+//pick lists the choices of a pick item, so the picked value is returned to the var name
+func (me *MenuEngine) pick(name, auto string, choices []*MenuChoice) {
+	pick := &MenuItemList{
+		Title: "Pick - " + name,
+		Items: make([]*MenuItem, 0),
+	}
+	if auto != "" {
+		pick.AddItem("Automatic", "return", auto)
+		pick.AddItem("", "divider", "1")
+	}
+	for _, choice := range choices {
+		pick.AddItem(choice.Name, "return", choice.Value)
+	}
+	if len(choices) == 0 {
+		pick.AddItem("Nothing to pick from", "note", "")
+	}
+
+	me.Return = name
+	me.AddMenu("INTERNAL_PICK", pick)
+	me.ChangeMenu("INTERNAL_PICK")
+}
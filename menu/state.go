@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/JoshuaDoes/json"
+)
+
+//MenuState holds what the menu remembers between sessions
+type MenuState struct {
+	Environment map[string]string `json:"environment"`
+	Menu        string            `json:"menu,omitempty"` //last menu from the configuration, with its history and cursor
+	MenuHistory []string          `json:"menuHistory,omitempty"`
+	ItemHistory []int             `json:"itemHistory,omitempty"`
+	ItemCursor  int               `json:"itemCursor"`
+}
+
+//StateFile saves the menu state to a file whenever it changes, and loads it at startup
+type StateFile struct {
+	Path      string
+	Transient map[string]bool //vars that are never saved
+	Menus     map[string]bool //menus from the configuration, as generated menus such as the explorer can't be resumed
+
+	state *MenuState
+	last  []byte //what was last written, to only write changes
+	menu  string //the menu loaded when the state was last saved, see Update
+}
+
+//NewStateFile returns a state file at path, skipping the transient vars and the menus that aren't in menus
+func NewStateFile(path string, transient []string, menus map[string]*MenuItemList) *StateFile {
+	sf := &StateFile{
+		Path:      path,
+		Transient: map[string]bool{"WORKINGDIR": true},
+		Menus:     make(map[string]bool),
+		state:     &MenuState{Environment: make(map[string]string)},
+	}
+	for _, name := range transient {
+		sf.Transient[name] = true
+	}
+	for id := range menus {
+		sf.Menus[id] = true
+	}
+	return sf
+}
+
+//Load reads the state file into the menu environment, doing nothing if there isn't one yet
+func (sf *StateFile) Load(me *MenuEngine) error {
+	data, err := ioutil.ReadFile(sf.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	state := &MenuState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return err
+	}
+	if state.Environment == nil {
+		state.Environment = make(map[string]string)
+	}
+	for name, value := range state.Environment {
+		if !sf.Transient[name] {
+			me.Environment[name] = value
+		}
+	}
+	sf.state = state
+	sf.last = data
+	return nil
+}
+
+//Resume returns to the menu and cursor that were saved, returning false if there's nothing to resume
+func (sf *StateFile) Resume(me *MenuEngine) bool {
+	state := sf.state
+	if state.Menu == "" || !sf.Menus[state.Menu] || len(state.MenuHistory) != len(state.ItemHistory) {
+		return false
+	}
+	for _, id := range state.MenuHistory {
+		if !sf.Menus[id] {
+			return false
+		}
+	}
+	me.MenuHistory = append([]string{}, state.MenuHistory...)
+	me.ItemHistory = append([]int{}, state.ItemHistory...)
+	me.LoadedMenu = state.Menu
+	me.refresh(me.Menus[me.LoadedMenu])
+	me.ItemCursor = state.ItemCursor
+	if me.ItemCursor < -1 || me.ItemCursor >= len(me.Menus[me.LoadedMenu].Items) || (me.ItemCursor == -1 && !me.isBackVisible()) {
+		me.ItemCursor = 0
+	}
+	me.render()
+	return true
+}
+
+//Update saves the state if a var changed or another menu was loaded since it was last saved
+//
+//Moving the cursor alone isn't saved, so browsing a menu doesn't write to storage on every key press, and is left to Save on exit
+func (sf *StateFile) Update(me *MenuEngine) error {
+	if me.LoadedMenu == sf.menu && sf.sameVars(me) {
+		return nil
+	}
+	return sf.Save(me)
+}
+
+//sameVars returns true if the vars that are saved haven't changed since the state was last saved
+func (sf *StateFile) sameVars(me *MenuEngine) bool {
+	saved := 0
+	for name, value := range me.Environment {
+		if sf.Transient[name] {
+			continue
+		}
+		if last, ok := sf.state.Environment[name]; !ok || last != value {
+			return false
+		}
+		saved++
+	}
+	return saved == len(sf.state.Environment)
+}
+
+//Save writes the state of the menu if it changed since it was last written
+//
+//The state is written to a temporary file first and renamed over the last one, so it's never left half written
+func (sf *StateFile) Save(me *MenuEngine) error {
+	sf.menu = me.LoadedMenu
+	state := &MenuState{Environment: make(map[string]string)}
+	for name, value := range me.Environment {
+		if !sf.Transient[name] {
+			state.Environment[name] = value
+		}
+	}
+
+	//Keep the deepest position within the configuration while in a generated menu, such as the item an exec item ran from
+	state.Menu, state.MenuHistory, state.ItemHistory, state.ItemCursor = sf.state.Menu, sf.state.MenuHistory, sf.state.ItemHistory, sf.state.ItemCursor
+	menus := append(append([]string{}, me.MenuHistory...), me.LoadedMenu)
+	cursors := append(append([]int{}, me.ItemHistory...), me.ItemCursor)
+	for depth := range menus {
+		if !sf.Menus[menus[depth]] {
+			break
+		}
+		state.Menu = menus[depth]
+		state.MenuHistory = append([]string{}, menus[:depth]...)
+		state.ItemHistory = append([]int{}, cursors[:depth]...)
+		state.ItemCursor = cursors[depth]
+	}
+
+	data, err := json.Marshal(state, true)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, sf.last) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sf.Path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(sf.Path), filepath.Base(sf.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), sf.Path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	sf.state = state
+	sf.last = data
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//newStateEngine returns a menu engine with a main menu leading to a settings menu
+func newStateEngine() *MenuEngine {
+	me := NewMenuEngine(func(string) {}, 80, 24)
+	me.AddMenu("main", &MenuItemList{Title: "Main", Items: []*MenuItem{
+		{Name: "Flash", Type: "exec", Action: "flash"},
+		{Name: "Settings", Type: "menu", Action: "settings"},
+	}})
+	me.AddMenu("settings", &MenuItemList{Title: "Settings", Items: []*MenuItem{
+		{Name: "Verbose", Type: "var verbose"},
+		{Name: "Slot", Type: "var slot"},
+	}})
+	return me
+}
+
+func TestStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "menu.json")
+	me := newStateEngine()
+	sf := NewStateFile(path, []string{"verbose"}, me.Menus)
+	if err := sf.Load(me); err != nil {
+		t.Fatalf("loading a missing state file: %v", err)
+	}
+
+	me.Environment["slot"] = "_b"
+	me.Environment["verbose"] = "1"
+	me.Environment["WORKINGDIR"] = "/tmp"
+	me.MenuHistory, me.ItemHistory = []string{"main"}, []int{1}
+	me.LoadedMenu, me.ItemCursor = "settings", 1
+	if err := sf.Save(me); err != nil {
+		t.Fatal(err)
+	}
+
+	//A generated menu opened from the settings keeps the settings as the position to resume
+	me.MenuHistory, me.ItemHistory = []string{"main", "settings"}, []int{1, 1}
+	me.LoadedMenu, me.ItemCursor = explorerMenu, 4
+	if err := sf.Save(me); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := newStateEngine()
+	resumed.Environment["verbose"] = "0"
+	loaded := NewStateFile(path, []string{"verbose"}, resumed.Menus)
+	if err := loaded.Load(resumed); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"slot": "_b", "verbose": "0"}
+	if !reflect.DeepEqual(resumed.Environment, expected) {
+		t.Errorf("loaded vars %v, expected %v", resumed.Environment, expected)
+	}
+	if !loaded.Resume(resumed) {
+		t.Fatal("nothing to resume")
+	}
+	if resumed.LoadedMenu != "settings" || resumed.ItemCursor != 1 || !reflect.DeepEqual(resumed.MenuHistory, []string{"main"}) || !reflect.DeepEqual(resumed.ItemHistory, []int{1}) {
+		t.Errorf("resumed %s at %d with history %v %v, expected settings at 1 with history [main] [1]", resumed.LoadedMenu, resumed.ItemCursor, resumed.MenuHistory, resumed.ItemHistory)
+	}
+
+	//Only a transient var changed since it was loaded, so nothing is written
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	resumed.Environment["verbose"] = "1"
+	if err := loaded.Update(resumed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("state file was written without a change: %v", err)
+	}
+	resumed.Environment["slot"] = "_a"
+	if err := loaded.Update(resumed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("state file wasn't written after a var changed: %v", err)
+	}
+}
+
+func TestStateFileLoad(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Data   string
+		Vars   map[string]string
+		Resume bool
+		Err    bool
+	}{
+		{"empty state", `{}`, map[string]string{}, false, false},
+		{"vars only", `{"environment": {"slot": "_a"}}`, map[string]string{"slot": "_a"}, false, false},
+		{"transient vars skipped", `{"environment": {"verbose": "1", "WORKINGDIR": "/", "slot": "_a"}}`, map[string]string{"slot": "_a"}, false, false},
+		{"menu", `{"menu": "settings", "menuHistory": ["main"], "itemHistory": [1], "itemCursor": 0}`, map[string]string{}, true, false},
+		{"unknown menu", `{"menu": "gone", "itemCursor": 0}`, map[string]string{}, false, false},
+		{"unknown menu in history", `{"menu": "settings", "menuHistory": ["gone"], "itemHistory": [0]}`, map[string]string{}, false, false},
+		{"mismatched history", `{"menu": "settings", "menuHistory": ["main"], "itemHistory": []}`, map[string]string{}, false, false},
+		{"corrupt", `{"environment": {"slot": `, nil, false, true},
+		{"wrong types", `{"environment": ["slot"]}`, nil, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "menu.json")
+			if err := ioutil.WriteFile(path, []byte(test.Data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			me := newStateEngine()
+			sf := NewStateFile(path, []string{"verbose"}, me.Menus)
+			err := sf.Load(me)
+			if test.Err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(me.Environment, test.Vars) {
+				t.Errorf("loaded vars %v, expected %v", me.Environment, test.Vars)
+			}
+			if resume := sf.Resume(me); resume != test.Resume {
+				t.Errorf("resumed %v, expected %v", resume, test.Resume)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/JoshuaDoes/jdtoolbox/partition"
+)
+
+//Vars returns a string formatted with all vars replaced
+//
+//Vars are written as $name or ${name}, ${name:-default} uses default when name is unset or empty, and $$ is a literal $
+//Unknown vars are kept as they are
+func (me *MenuEngine) Vars(in string) string {
+	return expandVars(in, me.lookup)
+}
+
+//lookup returns the value of a var and whether it's set, from the menu environment, then the built-in vars, then the process environment
+func (me *MenuEngine) lookup(name string) (string, bool) {
+	if value, ok := me.Environment[name]; ok {
+		return value, true
+	}
+	if value, ok := me.builtin(name); ok {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+//builtinVars holds the names of the built-in vars
+var builtinVars = []string{"SLOT", "DEVICE", "ANDROID_API"}
+
+//builtin returns the value of a built-in var, reading them all from the device the first time one is used
+func (me *MenuEngine) builtin(name string) (string, bool) {
+	if me.builtins == nil {
+		me.builtins = map[string]string{
+			"DEVICE":      getprop("ro.product.device"),
+			"ANDROID_API": getprop("ro.build.version.sdk"),
+		}
+		if table, err := partition.Open("/"); err == nil {
+			me.builtins["SLOT"] = table.Slot()
+		}
+	}
+	value, ok := me.builtins[name]
+	return value, ok
+}
+
+//getprop returns a system property, or an empty string if it isn't set or this isn't Android
+func getprop(name string) string {
+	output, err := exec.Command("getprop", name).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+//expandVars replaces the vars in text with their values, without splitting or unquoting anything
+func expandVars(text string, lookup func(string) (string, bool)) string {
+	out, _ := expand(text, lookup)
+	return out
+}
+
+//expand replaces the vars in text with their values, returning the names of the vars it couldn't replace
+func expand(text string, lookup func(string) (string, bool)) (string, []string) {
+	out := ""
+	unknown := make([]string, 0)
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '$' {
+			out += string(runes[i])
+			continue
+		}
+		value, n, missing := expandVar(runes[i+1:], lookup)
+		unknown = append(unknown, missing...)
+		if n == 0 {
+			out += "$"
+			continue
+		}
+		out += value
+		i += n
+	}
+	return out, unknown
+}
+
+//expandVar expands the var at the start of text, which follows a $, returning its value and how many runes it takes up
+//
+//It takes up no runes if text doesn't start with a known var, so the $ is kept as is, and returns the names of unknown vars
+func expandVar(text []rune, lookup func(string) (string, bool)) (string, int, []string) {
+	if len(text) == 0 {
+		return "", 0, nil
+	}
+	if text[0] == '$' {
+		return "$", 1, nil
+	}
+	if text[0] == '{' {
+		end := closingBrace(text)
+		if end < 0 {
+			return "", 0, nil
+		}
+		name, def := string(text[1:end]), ""
+		hasDefault := false
+		if i := strings.Index(name, ":-"); i >= 0 {
+			name, def, hasDefault = name[:i], name[i+2:], true
+		}
+		if value, ok := lookup(name); ok && (value != "" || !hasDefault) {
+			return value, end + 1, nil
+		}
+		if hasDefault {
+			value, unknown := expand(def, lookup)
+			return value, end + 1, unknown
+		}
+		return "", 0, []string{name}
+	}
+
+	n := 0
+	for n < len(text) && (text[n] == '_' || unicode.IsLetter(text[n]) || (n > 0 && unicode.IsDigit(text[n]))) {
+		n++
+	}
+	if n == 0 {
+		return "", 0, nil //Not a var, such as $? or a trailing $
+	}
+	if value, ok := lookup(string(text[:n])); ok {
+		return value, n, nil
+	}
+	return "", 0, []string{string(text[:n])}
+}
+
+//closingBrace returns the index of the brace closing the one text starts with, allowing vars with braces in defaults
+func closingBrace(text []rune) int {
+	depth := 0
+	for i, r := range text {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+//UnknownVars returns the vars a menu uses that are neither set nor set by any var, setvar or pick item, sorted
+func (me *MenuEngine) UnknownVars(menuID string) []string {
+	menu := me.Menus[menuID]
+	if menu == nil {
+		return nil
+	}
+
+	declared := make(map[string]bool)
+	for _, other := range me.Menus {
+		if other == nil {
+			continue
+		}
+		for _, item := range other.Items {
+			itemArgs := strings.Split(item.Type, " ")
+			switch itemArgs[0] {
+			case "var", "setvar", "pick":
+				if len(itemArgs) > 1 {
+					declared[itemArgs[1]] = true
+				}
+			}
+		}
+	}
+	lookup := func(name string) (string, bool) {
+		if declared[name] {
+			return "", true
+		}
+		return me.lookup(name)
+	}
+
+	found := make(map[string]bool)
+	check := func(text string) {
+		_, unknown := expand(text, lookup)
+		for _, name := range unknown {
+			found[name] = true
+		}
+	}
+	check(menu.Title)
+	if menu.Dynamic != nil {
+		check(menu.Dynamic.Exec)
+		check(menu.Dynamic.File)
+		check(menu.Dynamic.Action)
+	}
+	for _, item := range menu.Items {
+		check(item.Name)
+		check(item.Action)
+		check(item.Confirm)
+		check(item.VisibleIf)
+		check(item.EnabledIf)
+		for _, arg := range item.Argv {
+			check(arg)
+		}
+	}
+
+	unknown := make([]string, 0, len(found))
+	for name := range found {
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Text    string
+		Out     string
+		Unknown []string
+	}{
+		{"plain", "no vars here", "no vars here", []string{}},
+		{"var", "$a and $space", "one and a b", []string{}},
+		{"braces", "${a}b", "oneb", []string{}},
+		{"longest name", "$ab $a", "two one", []string{}},
+		{"name ends at other runes", "$a.img $a-x $a/ $a1", "one.img one-x one/ $a1", []string{"a1"}},
+		{"dollar dollar", "$$ $$a $$$a", "$ $a $one", []string{}},
+		{"default when unset", "${nope:-x y}", "x y", []string{}},
+		{"default when empty", "${empty:-x}", "x", []string{}},
+		{"no default when set", "${a:-x}", "one", []string{}},
+		{"empty without default", "[${empty}][$empty]", "[][]", []string{}},
+		{"empty default", "[${nope:-}]", "[]", []string{}},
+		{"var in default", "${nope:-$a/${ab}}", "one/two", []string{}},
+		{"nested default", "${nope:-${gone:-deep}}", "deep", []string{}},
+		{"unknown in default", "${nope:-$gone}", "$gone", []string{"gone"}},
+		{"unknown", "$nope ${gone}", "$nope ${gone}", []string{"nope", "gone"}},
+		{"not a var", "$? $1 $ $", "$? $1 $ $", []string{}},
+		{"unclosed brace", "${a", "${a", []string{}},
+		{"multibyte", "ünï $a •", "ünï one •", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			out, unknown := expand(test.Text, testLookup(testVars))
+			if out != test.Out {
+				t.Errorf("expanded to %q, expected %q", out, test.Out)
+			}
+			if !reflect.DeepEqual(unknown, test.Unknown) {
+				t.Errorf("unknown vars %q, expected %q", unknown, test.Unknown)
+			}
+		})
+	}
+}
+
+func TestUnknownVars(t *testing.T) {
+	me := NewMenuEngine(func(string) {}, 80, 24)
+	me.Environment["a"] = "one"
+	me.builtins = map[string]string{"SLOT": "_a"}
+	me.AddMenu("settings", &MenuItemList{Title: "Settings", Items: []*MenuItem{
+		{Name: "Verbose", Type: "var verbose"},
+		{Name: "Slot", Type: "pick slot", Action: "list-slots"},
+	}})
+	me.AddMenu("main", &MenuItemList{Title: "$a ${title:-Main}", Items: []*MenuItem{
+		{Name: "Flash $SLOT", Type: "exec", Action: "flash boot$SLOT $image", Confirm: "Flash ${image}?"},
+		{Name: "Log", Type: "exec", Argv: []string{"log", "$verbose", "$level"}, VisibleIf: "set $slot"},
+		{Name: "Reset", Type: "setvar set", Action: "1", EnabledIf: "$mode == $$fast"},
+		{Name: "$$HOME", Type: "note"},
+	}})
+
+	tests := []struct {
+		Menu    string
+		Unknown []string
+	}{
+		{"main", []string{"image", "level", "mode"}},
+		{"settings", []string{}},
+		{"missing", nil},
+	}
+	for _, test := range tests {
+		if unknown := me.UnknownVars(test.Menu); !reflect.DeepEqual(unknown, test.Unknown) {
+			t.Errorf("unknown vars of %s are %q, expected %q", test.Menu, unknown, test.Unknown)
+		}
+	}
+}
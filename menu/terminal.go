@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+//Terminal keys that can be bound, named after what they do in the menu
+const (
+	TermUp     = "up"     //up arrow or k
+	TermDown   = "down"   //down arrow or j
+	TermSelect = "select" //enter
+	TermBack   = "back"   //backspace or escape
+	TermQuit   = "quit"   //q
+)
+
+//TerminalListener reads key presses from a terminal, as an alternative to listening to input devices
+type TerminalListener struct {
+	Bindings map[string]func()
+	Terminal *os.File
+
+	state   *syscall.Termios //terminal state to restore when closed
+	running bool
+	closed  bool
+}
+
+func getTermios(f *os.File) (*syscall.Termios, error) {
+	termios := &syscall.Termios{}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(termios))); errno != 0 {
+		return nil, errno
+	}
+	return termios, nil
+}
+
+func setTermios(f *os.File, termios *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(termios))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+//NewTerminalListener returns a new terminal listener, putting the terminal in raw mode until it's closed
+func NewTerminalListener(terminal *os.File) (*TerminalListener, error) {
+	state, err := getTermios(terminal)
+	if err != nil {
+		return nil, err
+	}
+
+	//Read every key as it's pressed without echoing it, but keep Ctrl+C working
+	raw := *state
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO | syscall.ECHONL | syscall.IEXTEN
+	raw.Iflag &^= syscall.ICRNL | syscall.INLCR | syscall.IXON
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := setTermios(terminal, &raw); err != nil {
+		return nil, err
+	}
+
+	return &TerminalListener{
+		Bindings: make(map[string]func()),
+		Terminal: terminal,
+		state:    state,
+	}, nil
+}
+
+//Bind binds a terminal key to a handler, bind nil to remove the binding
+func (tl *TerminalListener) Bind(key string, handler func()) {
+	if tl.closed {
+		return
+	}
+	if handler == nil {
+		delete(tl.Bindings, key)
+		return
+	}
+	tl.Bindings[key] = handler
+}
+
+//Run starts the terminal listener and blocks until it's closed
+func (tl *TerminalListener) Run() {
+	if tl.running {
+		return
+	}
+	tl.running = true
+
+	buf := make([]byte, 16)
+	pending := make([]byte, 0) //the start of an arrow key from the last read
+	for tl.running {
+		n, err := tl.Terminal.Read(buf)
+		if err != nil || !tl.running {
+			break
+		}
+		keys, rest := parseKeys(append(pending, buf[:n]...))
+		pending = append(pending[:0], rest...)
+		for _, key := range keys {
+			if handler, ok := tl.Bindings[key]; ok {
+				handler()
+			}
+		}
+	}
+}
+
+//parseKeys returns the bindable keys in a read from the terminal, and the start of an arrow key left unfinished at its end
+func parseKeys(input []byte) ([]string, []byte) {
+	keys := make([]string, 0)
+	for i := 0; i < len(input); i++ {
+		switch c := input[i]; c {
+		case 0x1b:
+			//Arrow keys are sent as ESC [ A or ESC O A, anything else starting with ESC is a lone escape
+			if i+1 < len(input) && (input[i+1] == '[' || input[i+1] == 'O') {
+				if i+2 >= len(input) {
+					return keys, input[i:] //The rest of it comes with the next read
+				}
+				switch input[i+2] {
+				case 'A':
+					keys = append(keys, TermUp)
+				case 'B':
+					keys = append(keys, TermDown)
+				}
+				i += 2
+				continue
+			}
+			keys = append(keys, TermBack)
+		case 'k', 'K':
+			keys = append(keys, TermUp)
+		case 'j', 'J':
+			keys = append(keys, TermDown)
+		case '\r', '\n':
+			keys = append(keys, TermSelect)
+		case 0x7f, 0x08:
+			keys = append(keys, TermBack)
+		case 'q', 'Q':
+			keys = append(keys, TermQuit)
+		}
+	}
+	return keys, nil
+}
+
+//Close restores the terminal to how it was before the listener was created
+func (tl *TerminalListener) Close() {
+	if tl.closed {
+		return
+	}
+	tl.running = false
+	tl.closed = true
+
+	setTermios(tl.Terminal, tl.state)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Input string
+		Keys  []string
+		Rest  string
+	}{
+		{"arrows", "\x1b[A\x1b[B", []string{TermUp, TermDown}, ""},
+		{"application arrows", "\x1bOA\x1bOB", []string{TermUp, TermDown}, ""},
+		{"other arrows", "\x1b[C\x1b[D", []string{}, ""},
+		{"j and k", "jkJK", []string{TermDown, TermUp, TermDown, TermUp}, ""},
+		{"enter", "\r\n", []string{TermSelect, TermSelect}, ""},
+		{"backspace", "\x7f\x08", []string{TermBack, TermBack}, ""},
+		{"escape", "\x1b", []string{TermBack}, ""},
+		{"escape then key", "\x1bj", []string{TermBack, TermDown}, ""},
+		{"quit", "qQ", []string{TermQuit, TermQuit}, ""},
+		{"unbound", "x1 \t", []string{}, ""},
+		{"mixed", "j\x1b[Ak\r", []string{TermDown, TermUp, TermUp, TermSelect}, ""},
+		{"partial escape sequence", "j\x1b[", []string{TermDown}, "\x1b["},
+		{"partial application sequence", "\x1bO", []string{}, "\x1bO"},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			keys, rest := parseKeys([]byte(test.Input))
+			if !reflect.DeepEqual(keys, test.Keys) {
+				t.Errorf("keys %q, expected %q", keys, test.Keys)
+			}
+			if string(rest) != test.Rest {
+				t.Errorf("rest %q, expected %q", rest, test.Rest)
+			}
+		})
+	}
+
+	//The rest of a partial escape sequence finishes it in the next read
+	keys, rest := parseKeys([]byte("\x1b["))
+	if keys, rest = parseKeys(append(rest, 'B')); !reflect.DeepEqual(keys, []string{TermDown}) || len(rest) != 0 {
+		t.Errorf("finished partial escape sequence into keys %q and rest %q, expected [down]", keys, rest)
+	}
+}
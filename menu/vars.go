@@ -0,0 +1,207 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//inputCharsets holds the characters offered by the on-screen keyboard, in the order they're displayed
+var inputCharsets = []struct {
+	ID    string
+	Name  string
+	Chars string
+}{
+	{"INTERNAL_INPUT_LOWER", "Add lowercase letter ...", "abcdefghijklmnopqrstuvwxyz"},
+	{"INTERNAL_INPUT_UPPER", "Add uppercase letter ...", "ABCDEFGHIJKLMNOPQRSTUVWXYZ"},
+	{"INTERNAL_INPUT_DIGIT", "Add number ...", "0123456789"},
+	{"INTERNAL_INPUT_SYMBOL", "Add symbol ...", "-_./:=+,@#%&*()[]{}!?~'\"<>;|\\^`$"},
+}
+
+//MenuInput holds the state of the on-screen keyboard while a string var is being edited
+type MenuInput struct {
+	Var   string                   //the var to store the value in when saved
+	Value string                   //the value being edited
+	Limit int                      //the maximum length of the value, or <= 0 for unlimited
+	Save  func(value string) error //called with the value instead of storing it in Var and going back if set, keeping the keyboard open if it fails
+}
+
+//Var activates a typed var, storing its new value in the environment
+//varType is one of string[:limit], number[:min[:max]], file[:extension1[,extension2,...]], bool, or opts:opt1,opt2,[opt3,...]
+func (me *MenuEngine) Var(name, varType string) {
+	varArgs := strings.SplitN(varType, ":", 2)
+	varOpts := ""
+	if len(varArgs) > 1 {
+		varOpts = varArgs[1]
+	}
+
+	switch varArgs[0] {
+	case "bool":
+		value, _ := strconv.ParseBool(me.Environment[name])
+		me.Environment[name] = strconv.FormatBool(!value)
+		me.render()
+	case "opts":
+		opts := strings.Split(varOpts, ",")
+		if varOpts == "" {
+			me.ErrorText("No options for var " + name)
+			return
+		}
+		next := 0
+		for i, opt := range opts {
+			if opt == me.Environment[name] {
+				next = (i + 1) % len(opts)
+				break
+			}
+		}
+		me.Environment[name] = opts[next]
+		me.render()
+	case "number":
+		hasMin, hasMax := false, false
+		min, max := 0, 0
+		bounds := strings.Split(varOpts, ":")
+		if len(bounds) > 0 && bounds[0] != "" {
+			n, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				me.ErrorText("Invalid minimum for var " + name + ": " + bounds[0])
+				return
+			}
+			min, hasMin = n, true
+		}
+		if len(bounds) > 1 && bounds[1] != "" {
+			n, err := strconv.Atoi(bounds[1])
+			if err != nil {
+				me.ErrorText("Invalid maximum for var " + name + ": " + bounds[1])
+				return
+			}
+			max, hasMax = n, true
+		}
+
+		value, err := strconv.Atoi(me.Environment[name])
+		if err != nil {
+			value = min //Start from the minimum
+		} else {
+			value++
+			if hasMax && value > max {
+				value = min //Wrap around to the minimum
+			}
+		}
+		if hasMin && value < min {
+			value = min
+		}
+		me.Environment[name] = strconv.Itoa(value)
+		me.render()
+	case "file":
+		workingDir := "/sdcard/"
+		if value := me.Environment[name]; value != "" {
+			if _, err := os.Stat(filepath.Dir(value)); err == nil {
+				workingDir = filepath.Dir(value) + "/"
+			}
+		}
+		me.Return = name
+		opts := NewExplorerOptions()
+		if varOpts != "" {
+			opts.Filter = strings.Split(varOpts, ",")
+		}
+		me.Explorer(workingDir, "", opts)
+	case "string":
+		limit := 0
+		if varOpts != "" {
+			n, err := strconv.Atoi(varOpts)
+			if err != nil {
+				me.ErrorText("Invalid limit for var " + name + ": " + varOpts)
+				return
+			}
+			limit = n
+		}
+		me.Input(name, limit)
+	default:
+		me.ErrorText("Unknown type for var " + name + ": " + varType)
+	}
+}
+
+//Input opens the on-screen keyboard to edit a string var
+func (me *MenuEngine) Input(name string, limit int) {
+	me.openInput(&MenuInput{
+		Var:   name,
+		Value: me.Environment[name],
+		Limit: limit,
+	})
+}
+
+//openInput opens the on-screen keyboard to edit the value held by state
+func (me *MenuEngine) openInput(state *MenuInput) {
+	me.input = state
+
+	input := &MenuItemList{}
+	for _, charset := range inputCharsets {
+		chars := &MenuItemList{}
+		for _, char := range charset.Chars {
+			chars.AddItem(string(char), "input append", string(char))
+		}
+		me.AddMenu(charset.ID, chars)
+		input.AddItem(charset.Name, "menu", charset.ID)
+	}
+	input.AddItem("Add space", "input append", " ")
+	input.AddItem("Delete last character", "input delete", "")
+	input.AddItem("Clear", "input clear", "")
+	input.AddItem("", "divider", "1")
+	input.AddItem("Save", "input save", "")
+	me.AddMenu("INTERNAL_INPUT", input)
+
+	me.updateInput()
+	me.ChangeMenu("INTERNAL_INPUT")
+}
+
+//InputAction edits the value held by the on-screen keyboard
+func (me *MenuEngine) InputAction(action, char string) {
+	if me.input == nil {
+		me.ErrorText("No input is being edited")
+		return
+	}
+
+	switch action {
+	case "append":
+		if me.input.Limit <= 0 || len(me.input.Value)+len(char) <= me.input.Limit {
+			me.input.Value += char
+		}
+	case "delete":
+		if len(me.input.Value) > 0 {
+			me.input.Value = me.input.Value[:len(me.input.Value)-1]
+		}
+	case "clear":
+		me.input.Value = ""
+	case "save":
+		if save := me.input.Save; save != nil {
+			if err := save(me.input.Value); err != nil {
+				me.ErrorText(err.Error())
+				return
+			}
+			me.input = nil
+			return
+		}
+		me.Environment[me.input.Var] = me.input.Value
+		me.input = nil
+		me.PrevMenu()
+		return
+	default:
+		me.ErrorText("Unknown input action: " + action)
+		return
+	}
+
+	me.updateInput()
+	me.render()
+}
+
+//updateInput refreshes the titles of the on-screen keyboard to show the value being edited
+func (me *MenuEngine) updateInput() {
+	title := "Input - " + me.input.Var + ": " + me.input.Value + "_"
+	if me.input.Limit > 0 {
+		title += " (" + strconv.Itoa(len(me.input.Value)) + "/" + strconv.Itoa(me.input.Limit) + ")"
+	}
+
+	me.Menus["INTERNAL_INPUT"].Title = title
+	for _, charset := range inputCharsets {
+		me.Menus[charset.ID].Title = title
+	}
+}
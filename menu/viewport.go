@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	viewportMargin = 3 //blank lines the renderer adds after the menu
+	itemIndent     = 7 //width of the "   --> " cursor in front of items
+)
+
+//itemLines returns the lines an item takes up when rendered, hidden items taking up none
+func (me *MenuEngine) itemLines(item *MenuItem) int {
+	if !me.visible(item) {
+		return 0
+	}
+	if item.Type != "divider" {
+		return 1
+	}
+	if length, err := strconv.Atoi(item.Action); err == nil && length >= 0 {
+		return length
+	}
+	return 1
+}
+
+//truncate shortens text to fit width columns, marking where it was cut
+func truncate(text string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(text) <= width {
+		return text
+	}
+	if width <= 3 {
+		return string([]rune(text)[:width])
+	}
+	return string([]rune(text)[:width-3]) + "..."
+}
+
+//dim marks text to be drawn dimmed, such as disabled items
+func dim(text string) string {
+	return "\x1b[2m" + text + "\x1b[0m"
+}
+
+//wrap splits text into lines of at most width columns, keeping existing line breaks
+func wrap(text string, width int) []string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(text, "\n") {
+		runes := []rune(line)
+		for width > 0 && len(runes) > width {
+			lines = append(lines, string(runes[:width]))
+			runes = runes[width:]
+		}
+		lines = append(lines, string(runes))
+	}
+	return lines
+}
+
+//viewport returns the range of items to render so the item cursor stays visible within lines, scrolling as little as possible
+func (me *MenuEngine) viewport(items []*MenuItem, lines int) (int, int) {
+	if me.scrollMenu != me.LoadedMenu || me.scroll >= len(items) {
+		me.scrollMenu = me.LoadedMenu
+		me.scroll = 0
+	}
+	if me.ItemCursor >= 0 && me.ItemCursor < me.scroll {
+		me.scroll = me.ItemCursor
+	}
+
+	end := func(start int) int {
+		used, i := 0, start
+		for ; i < len(items); i++ {
+			used += me.itemLines(items[i])
+			if used > lines && i > start {
+				break
+			}
+		}
+		return i
+	}
+	for me.ItemCursor >= end(me.scroll) && me.scroll < me.ItemCursor {
+		me.scroll++
+	}
+	return me.scroll, end(me.scroll)
+}
+
+//countItems returns the amount of items shown, skipping dividers
+func (me *MenuEngine) countItems(items []*MenuItem) int {
+	count := 0
+	for _, item := range items {
+		if item.Type != "divider" && me.visible(item) {
+			count++
+		}
+	}
+	return count
+}
@@ -1,7 +1,5 @@
 #!/bin/sh
 
-source /data/adb/magisk/util_functions.sh
-
 P="  •"
 
 on_error() {
@@ -22,7 +20,7 @@ on_error() {
 on_done() {
     echo
     echo
-    echo "$P Kernel installed!"
+    [[ ! -z "$dry_run" ]] && echo "$P Preview complete, nothing was flashed!" || echo "$P Kernel installed!"
     echo
     echo
     echo
@@ -35,13 +33,24 @@ on_done() {
 trap on_error EXIT
 set -e
 
-find_part_by_name() {
-    #echo "/dev/block/by-name/$1"
-    echo $(find_block $1)
-}
+dry_run=""
+slot_args=""
+pick_kernel=""
+pick_dtb=""
+ak_args=""
+while true; do
+    case "$1" in
+        --dry-run) dry_run="--dry-run"; shift;;
+        --slot) slot_args="--slot $2"; shift 2;;
+        --pick-kernel) pick_kernel="$2"; shift 2;;
+        --pick-dtb) pick_dtb="$2"; shift 2;;
+        --force|--force=*|--ak-ramdisk|--ak-ramdisk=*|--ak-modules|--ak-modules=*) ak_args="$ak_args $1"; shift;;
+        *) break;;
+    esac
+done
 
 MAGISKBOOT=/data/adb/magisk/magiskboot
-(ls $MAGISKBOOT >> /dev/null 2>&1 && echo "$P Found magiskboot: $MAGISKBOOT") || (echo "$P Missing dependency magiskboot" && exit 1)
+(ls $MAGISKBOOT >> /dev/null 2>&1 && echo "$P Found magiskboot: $MAGISKBOOT") || echo "$P No magiskboot found, only needed for ramdisk edits and compression formats other than gzip"
 
 cat <<EOF
 
@@ -56,20 +65,9 @@ kernel_image=$1
 echo "$P Kernel image: $kernel_image"
 kernel_dtb=$2
 [[ ! -z "$kernel_dtb" ]] && echo "$P Kernel device tree blob: $kernel_dtb" || echo "$P No device tree blob specified, ignoring..."
-boot_slot="$(cat /proc/cmdline | tr ' ' '\n' | grep androidboot.slot_suffix | sed 's/.*=_\(.*\)/\1/')"
-[[ ! -z "$boot_slot" ]] && export boot_slot="_$boot_slot" && echo "$P Boot slot: $boot_slot" || echo "$P Boot has no secondary slots"
-echo "$P Scanning for boot partition, please wait..."
-boot_part="$(find_part_by_name boot$boot_slot)"
-[[ ! -z "$boot_part" ]] && echo "$P Boot partition: $boot_part" || (echo "$P No boot partition found" && exit 1) #How the hell don't you have boot?
-echo "$P Scanning for vendor boot partition, please wait..."
-vendor_boot_part="$(find_part_by_name vendor_boot$boot_slot)"
-[[ ! -z "$vendor_boot_part" ]] && echo "$P Vendor boot partition: $vendor_boot_part" || echo "$P No vendor boot partition found, ignoring..."
 echo
 
-part_args="--boot $boot_part"
-[[ ! -z "$vendor_boot_part" ]] && export part_args="$part_args --vendorboot $vendor_boot_part"
-[[ ! -z "$kernel_dtb" ]] && export part_args="$part_args --dtb $kernel_dtb"
-./bin/krnlinst --wd "$TMPDIR/" --magiskboot "$MAGISKBOOT" $part_args --kernel "$kernel_image"
+./bin/krnlinst --wd "$TMPDIR/" --magiskboot "$MAGISKBOOT" $dry_run $slot_args ${pick_kernel:+--pick-kernel "$pick_kernel"} ${pick_dtb:+--pick-dtb "$pick_dtb"} $ak_args ${kernel_dtb:+--dtb "$kernel_dtb"} --kernel "$kernel_image"
 
 #echo "$P Unpacking images..."
 #mkdir -p /data/local/tmp/boot_$boot_slot /data/local/tmp/vendor_boot_$boot_slot
@@ -0,0 +1,11 @@
+#!/bin/sh
+
+# Lists the installed Magisk modules for a dynamic menu, a line each with the module name and its directory separated by a tab
+
+for dir in /data/adb/modules/*/; do
+    [ -f "$dir/module.prop" ] || continue
+    name="$(grep -m 1 '^name=' "$dir/module.prop" | cut -d = -f 2-)"
+    [ -n "$name" ] || name="$(basename "$dir")"
+    [ -f "$dir/disable" ] && name="$name (disabled)"
+    printf '%s\t%s\n' "$name" "${dir%/}"
+done
@@ -1,7 +1,5 @@
 #!/bin/sh
 
-source /data/adb/magisk/util_functions.sh
-
 P="  •"
 
 on_error() {
@@ -20,7 +18,7 @@ on_error() {
 on_done() {
     echo
     echo
-    echo "$P TWRP installed!"
+    [[ ! -z "$dry_run" ]] && echo "$P Preview complete, nothing was flashed!" || echo "$P TWRP installed!"
     echo
     echo
     echo
@@ -33,13 +31,18 @@ on_done() {
 trap on_error EXIT
 set -e
 
-find_part_by_name() {
-    #echo "/dev/block/by-name/$1"
-    echo $(find_block $1)
-}
+dry_run=""
+slot_args=""
+while true; do
+    case "$1" in
+        --dry-run) dry_run="--dry-run"; shift;;
+        --slot) slot_args="--slot $2"; shift 2;;
+        *) break;;
+    esac
+done
 
 MAGISKBOOT=/data/adb/magisk/magiskboot
-(ls $MAGISKBOOT >> /dev/null 2>&1 && echo "$P Found magiskboot: $MAGISKBOOT") || (echo "$P Missing dependency magiskboot" && exit 1)
+(ls $MAGISKBOOT >> /dev/null 2>&1 && echo "$P Found magiskboot: $MAGISKBOOT") || echo "$P No magiskboot found, only needed for ramdisk edits and compression formats other than gzip"
 
 cat <<EOF
 
@@ -53,19 +56,9 @@ EOF
 
 twrp_image=$1
 echo "$P TWRP image: $twrp_image"
-boot_slot="$(cat /proc/cmdline | tr ' ' '\n' | grep androidboot.slot_suffix | sed 's/.*=_\(.*\)/\1/')"
-[[ ! -z "$boot_slot" ]] && export boot_slot="_$boot_slot" && echo "$P Boot slot: $boot_slot" || echo "$P Boot has no secondary slots"
-echo "$P Scanning for boot partition, please wait..."
-boot_part="$(find_part_by_name boot$boot_slot)"
-[[ ! -z "$boot_part" ]] && echo "$P Boot partition: $boot_part" || (echo "$P No boot partition found" && exit 1) #How the hell don't you have boot?
-echo "$P Scanning for recovery partition, please wait..."
-recovery_part="$(find_part_by_name recovery)"
-[[ ! -z "$recovery_part" ]] && echo "$P Recovery partition: $recovery_part" || echo "$P No recovery partition found, ignoring..."
 echo
 
-part_args="--boot $boot_part"
-[[ ! -z "$recovery_part" ]] && export part_args="--recovery $recovery_part"
-./bin/twrpinst --wd "$TMPDIR/" --magiskboot "$MAGISKBOOT" --twrp "$twrp_image" $part_args
+./bin/twrpinst --wd "$TMPDIR/" --magiskboot "$MAGISKBOOT" $dry_run $slot_args --twrp "$twrp_image"
 
 sync
 
@@ -137,11 +137,11 @@ on_install() {
   mkdir -p /data/adb/modules/jdtoolbox
   unzip -o "$ZIPFILE" "module.prop" -d $MODPATH >&2
   unzip -o "$ZIPFILE" "menu.json" -d $TMPDIR >&2
-  unzip -o "$ZIPFILE" "bin/file-$ARCH" -d $TMPDIR >&2
+  unzip -o "$ZIPFILE" "bin/bkrestore" -d $TMPDIR >&2
   unzip -o "$ZIPFILE" "bin/jdtoolbox" -d $TMPDIR >&2
   unzip -o "$ZIPFILE" "bin/KernelInstaller.sh" -d $TMPDIR >&2
   unzip -o "$ZIPFILE" "bin/krnlinst" -d $TMPDIR >&2
-  unzip -o "$ZIPFILE" "bin/magic.mgc" -d $TMPDIR >&2
+  unzip -o "$ZIPFILE" "bin/MagiskModules.sh" -d $TMPDIR >&2
   unzip -o "$ZIPFILE" "bin/TeamWinInstaller.sh" -d $TMPDIR >&2
   unzip -o "$ZIPFILE" "bin/twrpinst" -d $TMPDIR >&2
   unzip -o "$ZIPFILE" "bin/tput-$ARCH" -d $TMPDIR >&2
@@ -152,7 +152,7 @@ on_install() {
   ls -la $MODPATH/*
 
   ui_print "- Starting the menu..."
-  exec $TMPDIR/bin/jdtoolbox --menu $TMPDIR/menu.json --keyCalibration /data/adb/modules/jdtoolbox/keyCalibration.json --workingDir $TMPDIR --vLines "$($TMPDIR/bin/tput-$ARCH columns)" --hLines "$($TMPDIR/bin/tput-$ARCH lines)" 2>&1
+  exec $TMPDIR/bin/jdtoolbox --menu $TMPDIR/menu.json --keyCalibration /data/adb/modules/jdtoolbox/keyCalibration.json --state /data/adb/modules/jdtoolbox/state.json --menuDir /data/adb/modules/jdtoolbox/menus.d --workingDir $TMPDIR --hLines "$($TMPDIR/bin/tput-$ARCH cols)" --vLines "$($TMPDIR/bin/tput-$ARCH lines)" 2>&1
 
   ui_print ""
   ui_print ""
@@ -0,0 +1,295 @@
+//Package partition finds the block devices of named partitions and the active A/B slot, without relying on Magisk's find_block
+package partition
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//byNameDirs holds the directories of named partition symlinks, relative to the root, in order of preference
+var byNameDirs = []string{
+	"dev/block/by-name",
+	"dev/block/bootdevice/by-name",
+	"dev/block/platform/*/by-name",
+	"dev/block/platform/*/*/by-name",
+}
+
+//Table holds every named partition found under a root directory
+type Table struct {
+	Root       string            //root holding dev, sys and proc, usually /
+	Partitions map[string]string //block device of each partition name, such as boot_a
+}
+
+//Open scans root for named partitions, where root is / on a device or a fixture tree with the same layout
+func Open(root string) (*Table, error) {
+	t := &Table{Root: root, Partitions: make(map[string]string)}
+	for _, dir := range byNameDirs {
+		if err := t.scanByName(dir); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.scanSysfs(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+//path returns a path relative to the root
+func (t *Table) path(elem ...string) string {
+	return filepath.Join(append([]string{t.Root}, elem...)...)
+}
+
+//scanByName adds the partition symlinks found in the directories matching dir, keeping partitions that were already found
+func (t *Table) scanByName(dir string) error {
+	dirs, err := filepath.Glob(t.path(dir))
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		links, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, link := range links {
+			if _, exists := t.Partitions[link.Name()]; !exists {
+				t.Partitions[link.Name()] = filepath.Join(dir, link.Name())
+			}
+		}
+	}
+	return nil
+}
+
+//scanSysfs adds the partitions named by PARTNAME in sysfs uevent files, for devices without by-name symlinks
+func (t *Table) scanSysfs() error {
+	uevents, err := filepath.Glob(t.path("sys/class/block/*/uevent"))
+	if err != nil {
+		return err
+	}
+	for _, uevent := range uevents {
+		vars, err := readUevent(uevent)
+		if err != nil {
+			continue
+		}
+		name, devName := vars["PARTNAME"], vars["DEVNAME"]
+		if name == "" || devName == "" {
+			continue
+		}
+		if _, exists := t.Partitions[name]; !exists {
+			t.Partitions[name] = t.path("dev/block", devName)
+		}
+	}
+	return nil
+}
+
+//readUevent returns the KEY=value pairs of a uevent file
+func readUevent(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) == 2 {
+			vars[kv[0]] = kv[1]
+		}
+	}
+	return vars, scanner.Err()
+}
+
+//Names returns the name of every partition found, sorted
+func (t *Table) Names() []string {
+	names := make([]string, 0, len(t.Partitions))
+	for name := range t.Partitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Find returns the block device of a partition, such as boot_a
+func (t *Table) Find(name string) (string, error) {
+	if device, ok := t.Partitions[name]; ok {
+		return device, nil
+	}
+	return "", fmt.Errorf("partition [%s] not found", name)
+}
+
+//Name returns the name of the partition at a block device, or fallback if it isn't a known partition
+func (t *Table) Name(device, fallback string) string {
+	target, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		target = device
+	}
+	for _, name := range t.Names() {
+		partition := t.Partitions[name]
+		if partition == device {
+			return name
+		}
+		if resolved, err := filepath.EvalSymlinks(partition); err == nil && resolved == target {
+			return name
+		}
+	}
+	return fallback
+}
+
+//Slot returns the suffix of the slot the device booted from, such as _a, or an empty string if it doesn't use A/B slots
+//
+//The slot is read from the kernel command line, then bootconfig, then the A/B metadata on misc
+func (t *Table) Slot() string {
+	for _, file := range []string{"proc/cmdline", "proc/bootconfig"} {
+		data, err := ioutil.ReadFile(t.path(file))
+		if err != nil {
+			continue
+		}
+		if slot := parseSlot(string(data)); slot != "" {
+			return slot
+		}
+	}
+	if misc, err := t.Find("misc"); err == nil {
+		if slot, err := miscSlot(misc); err == nil {
+			return slot
+		}
+	}
+	return ""
+}
+
+//parseSlot returns the slot suffix set by androidboot.slot_suffix or androidboot.slot in a command line or bootconfig
+func parseSlot(data string) string {
+	//Bootconfig uses one key = "value" per line, the command line uses key=value separated by spaces
+	fields := strings.Fields(strings.NewReplacer(" = ", "=", "\"", "").Replace(data))
+	for _, key := range []string{"androidboot.slot_suffix=", "androidboot.slot="} {
+		for _, field := range fields {
+			if !strings.HasPrefix(field, key) {
+				continue
+			}
+			slot := strings.TrimPrefix(field, key)
+			if slot == "" {
+				continue
+			}
+			if !strings.HasPrefix(slot, "_") {
+				slot = "_" + slot
+			}
+			return slot
+		}
+	}
+	return ""
+}
+
+const (
+	//bootControlOffset is where the A/B boot control block sits in misc, in the slot_suffix field of bootloader_message_ab
+	bootControlOffset = 2048
+	bootControlSize   = 32
+	bootControlMagic  = 0x42414342
+)
+
+//readBootControl reads the boot control block on misc, checking its magic and CRC
+func readBootControl(misc string) ([]byte, error) {
+	f, err := os.Open(misc)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	bcb := make([]byte, bootControlSize)
+	if _, err := f.ReadAt(bcb, bootControlOffset); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(bcb[4:]) != bootControlMagic {
+		return nil, fmt.Errorf("no boot control block on [%s]", misc)
+	}
+	if crc32.ChecksumIEEE(bcb[:28]) != binary.LittleEndian.Uint32(bcb[28:]) {
+		return nil, fmt.Errorf("boot control block on [%s] fails its CRC", misc)
+	}
+	return bcb, nil
+}
+
+//miscSlot returns the slot suffix the bootloader would boot next, according to the boot control block on misc
+func miscSlot(misc string) (string, error) {
+	bcb, err := readBootControl(misc)
+	if err != nil {
+		return "", err
+	}
+
+	//Prefer the suffix the bootloader wrote, if it wrote one
+	if suffix := string(bytes.TrimRight(bcb[:4], "\x00")); suffix == "_a" || suffix == "_b" {
+		return suffix, nil
+	}
+
+	//Otherwise pick the bootable slot with the highest priority
+	slots := int(bcb[9] & 0x7)
+	best, bestPriority := -1, 0
+	for i := 0; i < slots && i < 2; i++ {
+		info := bcb[12+i*2]
+		priority, tries, successful := int(info&0xf), int(info>>4&0x7), info>>7 == 1
+		if priority > bestPriority && (tries > 0 || successful) {
+			best, bestPriority = i, priority
+		}
+	}
+	if best < 0 {
+		return "", fmt.Errorf("no bootable slot on [%s]", misc)
+	}
+	return "_" + string(rune('a'+best)), nil
+}
+
+//HasSlots returns true if the device uses A/B slots
+func (t *Table) HasSlots() bool {
+	_, a := t.Partitions["boot_a"]
+	_, b := t.Partitions["boot_b"]
+	return (a && b) || t.Slot() != ""
+}
+
+//OtherSlot returns the suffix of the slot that isn't slot, such as _b for _a
+func OtherSlot(slot string) string {
+	switch slot {
+	case "_a":
+		return "_b"
+	case "_b":
+		return "_a"
+	}
+	return ""
+}
+
+//Slots returns the slot suffixes chosen by current, other, both, a or b, relative to the slot the device booted from
+//
+//Devices without A/B slots only accept current, which returns a single empty suffix
+func (t *Table) Slots(choice string) ([]string, error) {
+	if !t.HasSlots() {
+		if choice != "current" && choice != "" {
+			return nil, fmt.Errorf("slot [%s] requested, but this device has no A/B slots", choice)
+		}
+		return []string{""}, nil
+	}
+
+	current := t.Slot()
+	switch choice {
+	case "current", "":
+		if current == "" {
+			return nil, fmt.Errorf("unable to find the current slot")
+		}
+		return []string{current}, nil
+	case "other":
+		if current == "" {
+			return nil, fmt.Errorf("unable to find the current slot")
+		}
+		return []string{OtherSlot(current)}, nil
+	case "both":
+		return []string{"_a", "_b"}, nil
+	case "a", "_a":
+		return []string{"_a"}, nil
+	case "b", "_b":
+		return []string{"_b"}, nil
+	}
+	return nil, fmt.Errorf("unknown slot [%s], expected current, other, both, a or b", choice)
+}
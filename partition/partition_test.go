@@ -0,0 +1,171 @@
+package partition
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//slotInfo packs the slot_metadata of a boot control block
+func slotInfo(priority, tries int, successful bool) byte {
+	info := byte(priority&0xf) | byte(tries&0x7)<<4
+	if successful {
+		info |= 1 << 7
+	}
+	return info
+}
+
+//bootControl returns a boot control block with two slots and a valid CRC
+func bootControl(suffix string, a, b byte) []byte {
+	bcb := make([]byte, bootControlSize)
+	copy(bcb, suffix)
+	binary.LittleEndian.PutUint32(bcb[4:], bootControlMagic)
+	bcb[8] = 1 //version
+	bcb[9] = 2 //nb_slot
+	bcb[12] = a
+	bcb[14] = b
+	binary.LittleEndian.PutUint32(bcb[28:], crc32.ChecksumIEEE(bcb[:28]))
+	return bcb
+}
+
+//fixture builds a root with by-name symlinks, a sysfs-only partition and a misc image holding bcb, returning the root
+//
+//proc maps files under proc, such as cmdline, to their contents
+func fixture(t *testing.T, bcb []byte, proc map[string]string) string {
+	root := t.TempDir()
+	write := func(path string, data []byte) {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(dir, name, target string) {
+		dir = filepath.Join(root, dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	misc := make([]byte, 8192)
+	for i := range misc {
+		misc[i] = 0xaa //So writes outside the boot control block would show
+	}
+	copy(misc[bootControlOffset:], bcb)
+	write("dev/block/sda1", []byte("boot a"))
+	write("dev/block/sda2", []byte("boot b"))
+	write("dev/block/sda3", misc)
+	write("dev/block/sda4", []byte("vendor_boot a"))
+	write("dev/block/sda9", []byte("dtbo a"))
+	link("dev/block/by-name", "boot_a", "../sda1")
+	link("dev/block/by-name", "boot_b", "../sda2")
+	link("dev/block/by-name", "misc", "../sda3")
+	link("dev/block/platform/soc/by-name", "boot_a", "../../../sda4") //Shadowed by dev/block/by-name
+	link("dev/block/platform/soc/by-name", "vendor_boot_a", "../../../sda4")
+	write("sys/class/block/sda9/uevent", []byte("MAJOR=8\nMINOR=9\nDEVNAME=sda9\nDEVTYPE=partition\nPARTNAME=dtbo_a\n"))
+	for name, data := range proc {
+		write(filepath.Join("proc", name), []byte(data))
+	}
+	return root
+}
+
+func TestOpen(t *testing.T) {
+	root := fixture(t, bootControl("", 0, 0), nil)
+	table, err := Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"boot_a", "boot_b", "dtbo_a", "misc", "vendor_boot_a"}
+	if names := table.Names(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("found partitions %v, expected %v", names, expected)
+	}
+	for name, device := range map[string]string{
+		"boot_a":        "dev/block/by-name/boot_a",
+		"vendor_boot_a": "dev/block/platform/soc/by-name/vendor_boot_a",
+		"dtbo_a":        "dev/block/sda9",
+	} {
+		found, err := table.Find(name)
+		if err != nil || found != filepath.Join(root, device) {
+			t.Errorf("found %s at %s (%v), expected %s", name, found, err, device)
+		}
+	}
+	if _, err := table.Find("recovery"); err == nil {
+		t.Errorf("found a partition that doesn't exist")
+	}
+	if name := table.Name(filepath.Join(root, "dev/block/sda2"), "unknown"); name != "boot_b" {
+		t.Errorf("named dev/block/sda2 %s, expected boot_b", name)
+	}
+	if name := table.Name(filepath.Join(root, "dev/block/sda7"), "unknown"); name != "unknown" {
+		t.Errorf("named dev/block/sda7 %s, expected the fallback", name)
+	}
+}
+
+func TestSlot(t *testing.T) {
+	noSlot := bootControl("", 0, 0)
+	tests := []struct {
+		name string
+		bcb  []byte
+		proc map[string]string
+		slot string
+	}{
+		{"cmdline suffix", noSlot, map[string]string{"cmdline": "console=ttyMSM0 androidboot.slot_suffix=_b quiet\n"}, "_b"},
+		{"cmdline slot", noSlot, map[string]string{"cmdline": "androidboot.slot=a\n"}, "_a"},
+		{"bootconfig", noSlot, map[string]string{"cmdline": "console=ttyMSM0\n", "bootconfig": "androidboot.hardware = \"qcom\"\nandroidboot.slot_suffix = \"_b\"\n"}, "_b"},
+		{"cmdline before bootconfig", noSlot, map[string]string{"cmdline": "androidboot.slot_suffix=_a\n", "bootconfig": "androidboot.slot_suffix = \"_b\"\n"}, "_a"},
+		{"misc suffix", bootControl("_b", slotInfo(15, 0, true), slotInfo(14, 0, true)), nil, "_b"},
+		{"misc priority", bootControl("", slotInfo(14, 0, true), slotInfo(15, 7, false)), nil, "_b"},
+		{"misc skips unbootable", bootControl("", slotInfo(14, 0, true), slotInfo(15, 0, false)), nil, "_a"},
+		{"misc bad magic", make([]byte, bootControlSize), nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table, err := Open(fixture(t, test.bcb, test.proc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if slot := table.Slot(); slot != test.slot {
+				t.Errorf("slot %q, expected %q", slot, test.slot)
+			}
+		})
+	}
+}
+
+func TestBootControlCRC(t *testing.T) {
+	bcb := bootControl("_a", slotInfo(15, 0, true), slotInfo(14, 0, true))
+	bcb[28] ^= 0xff
+	root := fixture(t, bcb, nil)
+	if _, err := miscSlot(filepath.Join(root, "dev/block/sda3")); err == nil {
+		t.Errorf("read a boot control block with a bad CRC")
+	}
+}
+
+func TestSlots(t *testing.T) {
+	table, err := Open(fixture(t, bootControl("", 0, 0), map[string]string{"cmdline": "androidboot.slot_suffix=_a\n"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for choice, expected := range map[string][]string{
+		"current": {"_a"},
+		"other":   {"_b"},
+		"both":    {"_a", "_b"},
+		"b":       {"_b"},
+	} {
+		slots, err := table.Slots(choice)
+		if err != nil || !reflect.DeepEqual(slots, expected) {
+			t.Errorf("slots %v (%v) for %s, expected %v", slots, err, choice, expected)
+		}
+	}
+	if _, err := table.Slots("c"); err == nil {
+		t.Errorf("accepted an unknown slot choice")
+	}
+}
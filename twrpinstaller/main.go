@@ -1,54 +1,110 @@
 package main
 
 import (
-	//"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
-	"runtime"
-	"strings"
 
 	flag "github.com/spf13/pflag"
+	"github.com/JoshuaDoes/jdtoolbox/backup"
+	"github.com/JoshuaDoes/jdtoolbox/bootimg"
+	"github.com/JoshuaDoes/jdtoolbox/codec"
+	"github.com/JoshuaDoes/jdtoolbox/filetype"
+	"github.com/JoshuaDoes/jdtoolbox/flash"
+	"github.com/JoshuaDoes/jdtoolbox/partition"
 )
 
 var (
 	wd, mb string
 	twrp, boot, recovery string
+	root, slot, slotChoice string
+	dryRun bool
+	backups string
+	keep int
+
+	plan *flash.Plan
+	parts *partition.Table
+	targets []*target
+	ramdisk []byte //patched and decompressed TWRP ramdisk, shared by every boot target
+	ramdiskFormat filetype.Type //compression of the TWRP ramdisk
 )
 
+//target holds the partition of a single slot to install to, either recovery or boot
+type target struct {
+	slot string
+	partition, name string
+	recovery bool
+}
+
 func init() {
 	flag.StringVar(&wd, "wd", "/tmp/", "path to tmp directory for process")
-	flag.StringVar(&mb, "magiskboot", "/data/adb/magisk/magiskboot", "path to magiskboot for repacking")
+	flag.StringVar(&mb, "magiskboot", "/data/adb/magisk/magiskboot", "path to magiskboot, only required to patch ramdisks and compress formats other than gzip")
 	flag.StringVar(&twrp, "twrp", "", "path to twrp to install")
-	flag.StringVar(&boot, "boot", "", "path to boot partition to repack, ignored with recovery")
-	flag.StringVar(&recovery, "recovery", "", "path to recovery partition to flash, invalidating boot repacking")
+	flag.StringVar(&boot, "boot", "", "path to boot partition to repack, ignored with recovery, found by name for the current slot if not given")
+	flag.StringVar(&recovery, "recovery", "", "path to recovery partition to flash, invalidating boot repacking, found by name if not given")
+	flag.StringVar(&slotChoice, "slot", "current", "slot to install to: current, other, both, a or b")
+	flag.StringVar(&root, "root", "/", "path to the root holding dev, sys and proc, used to find partitions and the current slot")
+	flag.BoolVar(&dryRun, "dry-run", false, "prepare everything in the tmp directory and print a plan without writing any partitions")
+	flag.StringVar(&backups, "backups", "/sdcard/jdtoolbox/backups", "path to backup store for partitions before they're modified")
+	flag.IntVar(&keep, "keep", 5, "backups to keep per partition and slot, <= 0 keeps all of them")
 	flag.Parse()
 
+	plan = flash.NewPlan(dryRun, backup.NewStore(backups, keep), "twrpinst")
+
 	if _, err := os.Stat(wd); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	if _, err := os.Stat(mb); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
 	if _, err := os.Stat(twrp); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	if boot != "" {
-		if _, err := os.Stat(boot); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+
+	var err error
+	parts, err = partition.Open(root)
+	check(err)
+	slot = parts.Slot()
+	slots, err := parts.Slots(slotChoice)
+	check(err)
+	if (boot != "" || recovery != "") && len(slots) > 1 {
+		check(fmt.Errorf("--boot and --recovery can only be used when installing to a single slot"))
 	}
-	if recovery != "" {
-		if _, err := os.Stat(recovery); err != nil {
+
+	for _, s := range slots {
+		t := &target{slot: s, partition: recovery, recovery: true}
+		if t.partition == "" && boot == "" {
+			//Devices with recovery in boot have no recovery partition
+			if t.partition, err = parts.Find("recovery" + s); err != nil {
+				t.partition, _ = parts.Find("recovery")
+			}
+		}
+		if t.partition == "" {
+			t.partition, t.recovery = boot, false
+			if t.partition == "" {
+				t.partition, err = parts.Find("boot" + s)
+				check(err)
+			}
+		}
+
+		if _, err := os.Stat(t.partition); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+		if t.recovery {
+			t.name = parts.Name(t.partition, "recovery"+s)
+		} else {
+			t.name = parts.Name(t.partition, "boot"+s)
+		}
+
+		//A recovery without slots is shared by both slots, so it's only flashed once
+		duplicate := false
+		for _, other := range targets {
+			duplicate = duplicate || other.partition == t.partition
+		}
+		if !duplicate {
+			targets = append(targets, t)
+		}
 	}
 }
 
@@ -67,87 +123,133 @@ func check(err error) {
 }
 
 func main() {
-	typeTWRP := file(twrp)
-	if !strings.Contains(typeTWRP, "Android bootimg") {
-		check(fmt.Errorf("[%s] is not an Android bootimg: %s", twrp, typeTWRP))
+	typeTWRP := detect(twrp)
+	if typeTWRP != filetype.BootImage {
+		check(fmt.Errorf("[%s] is not an Android boot image: %s", twrp, typeTWRP))
 	}
-	log("Successfully validated TWRP as " + typeTWRP)
-
-	if recovery != "" {
-		twrpRecovery()
-	} else if boot != "" {
-		twrpBoot()
+	log("Successfully validated TWRP as " + typeTWRP.String())
+	if slot != "" {
+		log("Boot slot: " + slot)
 	} else {
-		check(fmt.Errorf("What are we supposed to do, exactly?"))
+		log("Boot has no secondary slots")
+	}
+	for _, t := range targets {
+		if t.recovery {
+			log("Recovery partition: " + t.partition + " (" + t.name + ")")
+		} else {
+			log("No recovery partition found, using boot partition: " + t.partition + " (" + t.name + ")")
+		}
+	}
+	if dryRun {
+		log("Dry run, no partitions will be written")
+	}
+
+	for _, t := range targets {
+		if t.recovery {
+			twrpRecovery(t)
+		} else {
+			twrpBoot(t)
+		}
 	}
-}
 
-func twrpBoot() {
-	log("Backing up boot to [/sdcard/boot.img]...")
-	check(cp(boot, "/sdcard/boot.img"))
+	log("")
+	plan.Print(log)
+}
 
-	typeBoot := file("/sdcard/boot.img")
-	if !strings.Contains(typeBoot, "Android bootimg") {
-		check(fmt.Errorf("[%s] is not an Android bootimg: %s", boot, typeBoot))
+func twrpBoot(t *target) {
+	if !dryRun {
+		log("Backing up " + t.name + " to [" + backups + "]...")
+		backupLog(plan.Backup(t.partition, t.name))
 	}
-	log("Successfully validated boot as " + typeBoot)
 
-	log("Unpacking boot to [" + wd+"boot]...")
-	check(os.MkdirAll(wd+"boot", 0644))
-	check(run(mb, wd+"boot", "unpack", "-h", "-n", boot))
+	typeBoot := detect(t.partition)
+	if typeBoot != filetype.BootImage {
+		check(fmt.Errorf("[%s] is not an Android boot image: %s", t.partition, typeBoot))
+	}
+	log("Successfully validated " + t.name + " as " + typeBoot.String())
 
-	log("Unpacking TWRP to [" + wd+"twrp]...")
-	check(os.MkdirAll(wd+"twrp", 0644))
-	check(run(mb, wd+"twrp", "unpack", "-h", "-n", twrp))
+	log("Unpacking " + t.name + "...")
+	bootImg, err := bootimg.Open(t.partition)
+	check(err)
+	log(fmt.Sprintf("Boot image is header v%d, OS version %s", bootImg.HeaderVersion, bootImg.OSVersion))
 
-	log("Decompressing TWRP ramdisk...")
-	check(run(mb, wd+"twrp", "decompress", wd+"twrp/ramdisk.cpio", wd+"twrp/ramdiskdecomp.cpio"))
+	if ramdisk == nil {
+		ramdisk, ramdiskFormat = patchRamdisk()
+	}
 
-	log("Replacing boot ramdisk with decompressed TWRP ramdisk...")
-	check(cp(wd+"twrp/ramdiskdecomp.cpio", wd+"boot/ramdisk.cpio"))
+	//The kernel of the boot image may only support the compression of its own ramdisk, so the TWRP ramdisk is recompressed to match it
+	format := filetype.Detect(bootImg.Ramdisk)
+	if len(bootImg.Ramdisk) == 0 {
+		format = ramdiskFormat
+	}
+	log("Compressing patched TWRP ramdisk as " + format.String() + "...")
+	bootImg.Ramdisk, err = (&codec.Magiskboot{Path: mb, Dir: wd}).Compress(ramdisk, format)
+	if err != nil {
+		check(fmt.Errorf("unable to compress TWRP ramdisk like the ramdisk of %s: %v", t.name, err))
+	}
 
-	log("Patching boot ramdisk...")
-	check(run(mb, wd+"boot", "cpio", wd+"boot/ramdisk.cpio", "patch"))
+	log("Replacing " + t.name + " ramdisk with patched TWRP ramdisk...")
+	plan.Source("ramdisk", "ramdisk (patched)", "TWRP ["+twrp+"]")
+	plan.Source("kernel "+t.name, "kernel", "boot ["+t.partition+"]")
 
-	log("Repacking boot...")
-	check(run(mb, wd+"boot", "repack", boot, "new.img"))
+	log("Repacking " + t.name + "...")
+	newImg := wd + "new" + t.slot + ".img"
+	check(bootImg.WriteFile(newImg))
 
-	log("Flashing boot...")
-	check(cp(wd+"boot/new.img", boot))
+	if !dryRun {
+		log("Flashing " + t.name + "...")
+	}
+	check(plan.Flash(newImg, t.partition))
 }
 
-func twrpRecovery() {
-	log("Backing up recovery to [/sdcard/recovery.img]...")
-	check(cp(recovery, "/sdcard/recovery.img"))
+//patchRamdisk returns the decompressed TWRP ramdisk patched by magiskboot and the format it was compressed with
+func patchRamdisk() ([]byte, filetype.Type) {
+	log("Unpacking TWRP...")
+	twrpImg, err := bootimg.Open(twrp)
+	check(err)
+	log(fmt.Sprintf("TWRP image is header v%d, OS version %s", twrpImg.HeaderVersion, twrpImg.OSVersion))
+	if len(twrpImg.Ramdisk) == 0 {
+		check(fmt.Errorf("[%s] has no ramdisk", twrp))
+	}
 
-	log("Flashing recovery...")
-	check(cp(twrp, recovery))
-}
+	log("Decompressing TWRP ramdisk to [" + wd+"twrp]...")
+	magisk := &codec.Magiskboot{Path: mb, Dir: wd+"twrp"}
+	check(os.MkdirAll(wd+"twrp", 0755))
+	cpio, format, err := magisk.Decompress(twrpImg.Ramdisk)
+	check(err)
+	check(ioutil.WriteFile(wd+"twrp/ramdisk.cpio", cpio, 0644))
 
-func run(prog, dir string, args ...string) error {
-	cmd := exec.Command(prog, args...)
-	//cmd.Stdout = os.Stdout
-	cmd.Stdout = os.NewFile(0, os.DevNull)
-	//cmd.Stderr = os.Stderr
-	cmd.Stderr = os.NewFile(0, os.DevNull)
-	cmd.Stdin = os.Stdin
-	cmd.Dir = dir
-	return cmd.Run()
+	log("Patching TWRP ramdisk...")
+	check(magisk.Run("cpio", wd+"twrp/ramdisk.cpio", "patch"))
+
+	patched, err := ioutil.ReadFile(wd+"twrp/ramdisk.cpio")
+	check(err)
+	check(os.RemoveAll(wd+"twrp"))
+	return patched, format
 }
 
-func cp(src, dst string) error {
-	data, err := ioutil.ReadFile(src)
-	if err != nil {
-		return err
+func twrpRecovery(t *target) {
+	if !dryRun {
+		log("Backing up " + t.name + " to [" + backups + "]...")
+		backupLog(plan.Backup(t.partition, t.name))
+
+		log("Flashing " + t.name + "...")
 	}
-	return ioutil.WriteFile(dst, data, 0644)
+	plan.Source("recovery", twrp, "")
+	check(plan.Flash(twrp, t.partition))
 }
 
-func file(path string) string {
-	fileType, err := exec.Command(wd+"bin/file-" + runtime.GOARCH, path).Output()
+//detect returns the type of a file, or filetype.Unknown if it can't be read
+func detect(path string) filetype.Type {
+	fileType, err := filetype.DetectFile(path)
 	if err != nil {
-		return fmt.Sprintf("invalid: %v", err)
+		return filetype.Unknown
 	}
+	return fileType
+}
 
-	return string(fileType[len(path)+2:len(fileType)-1])
+//backupLog logs where a backup was kept, exiting if it failed
+func backupLog(m *backup.Manifest, err error) {
+	check(err)
+	log("Backed up " + m.Name() + " to [" + m.ImagePath() + "]")
 }